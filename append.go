@@ -0,0 +1,90 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Append adds a single message to the end of the named mbox file, creating the
+// file if necessary.  The content must be an RFC 5322 message; the envelope
+// supplies its From marker line.
+//
+// While appending, Append holds the mailbox locks described by LockMailbox.
+// Should another process replace the mailbox file while Append waits for
+// them, Append writes to the replacement.
+//
+// The message is written in the dialect DetectDialectAt finds in the existing
+// file; an empty file is taken to be Mboxo, so the first message is escaped
+// just as later ones will be.  If the existing file doesn't end with a blank
+// line, one is added first, so the new From marker is properly separated from
+// the previous message.  Data is flushed to stable
+// storage before Append returns.  Should anything fail, the file is truncated
+// back to its original length.
+func Append(path string, env Envelope, content io.Reader) (err error) {
+	raw, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	f, lock, err := openLocked(path, os.O_RDWR|os.O_CREATE, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}()
+	defer lock.Unlock()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	var buf bytes.Buffer
+	d, err := DetectDialectAt(f)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		sep, err := separatorNeeded(f, size)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(sep)
+	}
+	if err = NewWriter(&buf, d).WriteMessage(env, bytes.NewReader(raw)); err != nil {
+		return err
+	}
+
+	_, err = f.WriteAt(buf.Bytes(), size)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(size)
+		f.Sync()
+	}
+	return err
+}
+
+// separatorNeeded answers the line terminators which must be written so that
+// a file of the given size ends with a blank line.
+func separatorNeeded(f *os.File, size int64) (string, error) {
+	tail := make([]byte, min(size, 3))
+	if _, err := f.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return "", err
+	}
+	switch {
+	case bytes.HasSuffix(tail, []byte("\n\n")), bytes.HasSuffix(tail, []byte("\n\r\n")):
+		return "", nil
+	case bytes.HasSuffix(tail, []byte("\n")):
+		return "\n", nil
+	}
+	return "\n\n", nil
+}
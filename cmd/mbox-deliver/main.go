@@ -0,0 +1,107 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mbox-deliver is a local delivery agent.  It reads a single RFC 5322
+// message from standard input and appends it to an mbox file, holding the
+// locks procmail and mutt expect while it does.
+//
+// Usage:
+//
+//	mbox-deliver [-f sender] mailbox
+//
+// The envelope sender is taken from -f, or failing that, from the message's
+// header as mbox.EnvelopeOf finds it; the envelope date is the time of
+// delivery.  If the input already starts with a From marker line, as some MTAs
+// supply, that line is dropped, and its sender used when neither of the others
+// gives one.  Body lines are escaped as the mailbox's dialect requires.  Like
+// procmail, mbox-deliver stores messages even if their headers are malformed;
+// refusing them would only bounce them.
+//
+// For the MTA's benefit, mbox-deliver exits with the status codes of
+// sysexits.h: zero once the message is safely stored, and a nonzero code
+// otherwise.  Most MTAs bounce a message for any code but 75 (EX_TEMPFAIL), so
+// mbox-deliver reserves the others for failures which retrying can't cure: a
+// missing directory or a lack of permission.  Anything else, such as a locked
+// mailbox, a full disk or a failed read, asks the MTA to try again later.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// Exit codes, as defined by sysexits.h.
+const (
+	exUsage     = 64
+	exNoInput   = 66
+	exCantCreat = 73
+	exTempFail  = 75
+	exNoPerm    = 77
+)
+
+func main() {
+	sender := flag.String("f", "", "envelope `sender`; defaults to the one the message's header implies")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mbox-deliver: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exUsage)
+	}
+	path := flag.Arg(0)
+
+	raw, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fail(exTempFail, err)
+	}
+	if len(raw) == 0 {
+		fail(exNoInput, errors.New("no message on standard input"))
+	}
+
+	err = deliver(path, *sender, raw)
+	switch {
+	case err == nil:
+	case os.IsPermission(err):
+		fail(exNoPerm, err)
+	case os.IsNotExist(err):
+		fail(exCantCreat, err)
+	default:
+		fail(exTempFail, err)
+	}
+}
+
+// fail reports an error and exits with the given status.
+func fail(status int, err error) {
+	log.Print(err)
+	os.Exit(status)
+}
+
+// deliver appends the raw message to the mailbox at path, choosing its
+// envelope sender as described above.
+func deliver(path, sender string, raw []byte) error {
+	// An MTA may hand over the message with its From marker line already
+	// attached.
+	var marker mbox.Envelope
+	if bytes.HasPrefix(raw, []byte("From ")) {
+		line, rest, _ := bytes.Cut(raw, []byte("\n"))
+		marker, _ = mbox.ParseEnvelope(string(line))
+		raw = rest
+	}
+
+	env := mbox.Envelope{Sender: sender, Date: time.Now()}
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil && env.Sender == "" {
+		env.Sender = mbox.EnvelopeOf(msg.Header).Sender
+	}
+	if env.Sender == "" {
+		env.Sender = marker.Sender
+	}
+	return mbox.Append(path, env, bytes.NewReader(raw))
+}
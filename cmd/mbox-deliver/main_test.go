@@ -0,0 +1,78 @@
+// vim: ts=8 noexpandtab ai
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// Given a mailbox holding a message whose Content-Length doesn't delimit its
+// body, and no mailbox at all
+// When I deliver messages carrying Content-Length headers and From lines in
+// their bodies
+// Then I expect every such line escaped, and every message read back.
+func TestDeliver10(t *testing.T) {
+	existing := map[string]string{
+		"stray": "From a@b Mon Jan  2 15:04:05 2006\nSubject: x\nContent-Length: 5\n\nhello\n\n",
+		"empty": "",
+	}
+	for name, mailbox := range existing {
+		path := filepath.Join(t.TempDir(), name)
+		if mailbox != "" {
+			if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, msg := range []string{
+			"Return-Path: <c@d>\nSubject: y\nContent-Length: 13\n\nFrom here on\n",
+			"From e@f Tue Jan  3 15:04:05 2006\nSubject: z\n\nhi\nFrom there\n",
+		} {
+			if err := deliver(path, "", []byte(msg)); err != nil {
+				t.Fatal(name, ": ", err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "\n>From here on\n") || !strings.Contains(string(data), "\n>From there\n") {
+			t.Errorf("%s: Expected From lines escaped:\n%s", name, data)
+		}
+		if !strings.Contains(string(data), "From c@d ") || !strings.Contains(string(data), "\nFrom e@f ") {
+			t.Errorf("%s: Expected senders from Return-Path and the From marker:\n%s", name, data)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := mbox.CreateMboxStream(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for ; ; n++ {
+			msg, err := s.ReadMessage()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(name, ": message ", n+1, ": ", err)
+			}
+			if _, err := io.Copy(io.Discard, msg.BodyReader()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		f.Close()
+		if want := strings.Count(mailbox, "\nSubject: ") + 2; n != want {
+			t.Errorf("%s: Expected %d messages; got %d", name, want, n)
+		}
+	}
+}
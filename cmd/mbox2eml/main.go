@@ -0,0 +1,97 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mbox2eml exports the messages of an mbox file as standalone RFC 5322
+// .eml files.
+//
+// Usage:
+//
+//	mbox2eml [-dir directory] [-by index|message-id] [-dialect auto|mboxo|mboxrd|mboxcl|mboxcl2] mailbox
+//	mbox2eml -n N [-dialect ...] mailbox > message.eml
+//
+// By default, every message is written into the current directory.  With -n,
+// only the N'th message (counting from one) is exported, to standard output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sam-falvo/mbox"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory in which to write .eml files")
+	by := flag.String("by", "index", "name files by `index` or message-id")
+	dialect := flag.String("dialect", "auto", "dialect of the mailbox, or auto to detect it")
+	n := flag.Int("n", 0, "export only the n'th message, to standard output")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mbox2eml: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	d, err := mbox.ParseDialect(*dialect)
+	if *dialect == "auto" {
+		d, err = mbox.DetectDialectAt(f)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := mbox.CreateMboxStream(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *n > 0 {
+		if err := exportOne(s, *n, d); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	naming := mbox.NameByIndex
+	switch *by {
+	case "index":
+	case "message-id":
+		naming = mbox.NameByMessageID
+	default:
+		log.Fatalf("unknown naming scheme %q", *by)
+	}
+	count, err := mbox.ExportEML(s, *dir, d, naming)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "%d messages exported\n", count)
+}
+
+// exportOne writes the n'th message of the stream to standard output.
+func exportOne(s *mbox.MboxStream, n int, d mbox.Dialect) error {
+	for i := 1; ; i++ {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return fmt.Errorf("mailbox holds only %d messages", i-1)
+		}
+		if err != nil {
+			return err
+		}
+		if i == n {
+			return msg.WriteEML(os.Stdout, d)
+		}
+		if _, err := io.Copy(io.Discard, msg.BodyReader()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mbox2jsonl converts an mbox file to JSON Lines, one object per
+// message.
+//
+// Usage:
+//
+//	mbox2jsonl [-headers list] [-body] [-decode] [-attachments] [-dialect d] [-o output] mailbox
+//
+// Each object holds the message's byte offset and size, its envelope sender
+// and date, and its header fields, in order and including duplicates.  The
+// -headers flag restricts the fields to a comma-separated list of names; the
+// other flags add the body, decoded to text if asked, and a list of
+// attachments.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sam-falvo/mbox"
+)
+
+func main() {
+	headers := flag.String("headers", "", "include only the header fields in the comma-separated `list`")
+	body := flag.Bool("body", false, "include each message's body")
+	decode := flag.Bool("decode", false, "decode bodies to text, and encoded words in headers")
+	attachments := flag.Bool("attachments", false, "include attachment metadata")
+	dialect := flag.String("dialect", "auto", "dialect of the mailbox, or auto to detect it")
+	output := flag.String("o", "", "write to `file` rather than standard output")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mbox2jsonl: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	opts := &mbox.JSONLOptions{Body: *body, Decode: *decode, Attachments: *attachments}
+	if *headers != "" {
+		for _, name := range strings.Split(*headers, ",") {
+			opts.Headers = append(opts.Headers, strings.TrimSpace(name))
+		}
+	}
+	opts.Dialect, err = mbox.ParseDialect(*dialect)
+	if *dialect == "auto" {
+		opts.Dialect, err = mbox.DetectDialectAt(f)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := mbox.CreateMboxStream(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+	}
+	n, err := mbox.ExportJSONL(s, out, opts)
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "%d messages exported\n", n)
+}
@@ -0,0 +1,116 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mboxdedupe copies an mbox file, dropping duplicate messages.
+//
+// Usage:
+//
+//	mboxdedupe [-by message-id|header|body] [-report file] -o output mailbox
+//
+// The first occurrence of each message is kept, byte for byte.  A report
+// naming each dropped message, and the message it duplicated, is written to
+// standard error, or to the file given by -report.  The output replaces any
+// existing file only once it's complete, so it may name the input itself.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sam-falvo/mbox"
+)
+
+func main() {
+	by := flag.String("by", "message-id", "compare messages by `key`: message-id, header or body")
+	output := flag.String("o", "", "write the deduplicated mailbox to `file`")
+	report := flag.String("report", "", "write the report of dropped messages to `file`")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mboxdedupe: ")
+
+	if flag.NArg() != 1 || *output == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	key, err := mbox.ParseDedupeKey(*by)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	in, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	dialect, err := mbox.DetectDialectAt(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := mbox.CreateMboxStream(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(*output), "."+filepath.Base(*output)+".tmp")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fail := func(err error) {
+		os.Remove(out.Name())
+		log.Fatal(err)
+	}
+	rep := os.Stderr
+	if *report != "" {
+		if rep, err = os.Create(*report); err != nil {
+			fail(err)
+		}
+	}
+
+	d := mbox.NewDeduper(key)
+	var offsets []int64
+	kept, dropped := 0, 0
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+		}
+		var raw bytes.Buffer
+		if _, err := msg.WriteTo(&raw); err != nil {
+			fail(err)
+		}
+		body := raw.Bytes()[len(msg.RawHeader()):]
+
+		n := len(offsets)
+		offsets = append(offsets, msg.Offset())
+		if first, dup := d.Check(msg, body, dialect); dup {
+			dropped++
+			fmt.Fprintf(rep, "dropped message %d (offset %d, %q): duplicate of message %d (offset %d) by %v\n",
+				n+1, msg.Offset(), mbox.DecodeHeader(msg.MailHeader().Get("Subject")), first+1, offsets[first], key)
+			continue
+		}
+		kept++
+		if _, err := out.Write(raw.Bytes()); err != nil {
+			fail(err)
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		fail(err)
+	}
+	if err := os.Rename(out.Name(), *output); err != nil {
+		fail(err)
+	}
+	fmt.Fprintf(rep, "%d messages kept, %d dropped\n", kept, dropped)
+	if rep != os.Stderr {
+		if err := rep.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
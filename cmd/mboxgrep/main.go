@@ -0,0 +1,273 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mboxgrep searches an mbox file for messages matching a set of
+// predicates, all of which must hold.
+//
+// Usage:
+//
+//	mboxgrep [flags] mailbox
+//
+// Predicates:
+//
+//	-H 'Name=regexp'  a header named Name has a value matching regexp (repeatable)
+//	-e regexp         the body matches regexp
+//	-text             match -e against decoded MIME text parts only
+//	-sender regexp    the envelope sender or From address matches regexp
+//	-after date       the message is dated on or after date (YYYY-MM-DD)
+//	-before date      the message is dated before date (YYYY-MM-DD)
+//	-larger size      the message is larger than size (e.g. 10K, 2M)
+//	-smaller size     the message is smaller than size
+//	-i                regular expressions ignore case
+//	-v                select messages which don't match
+//
+// Output defaults to one summary line per matching message.  Use -c to print
+// only a count, or -o file to write the matching messages to a new mbox file.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("expected Name=regexp")
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+// A headerMatch requires some value of a named header to match a pattern.
+type headerMatch struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// criteria holds the compiled predicates.
+type criteria struct {
+	headers       []headerMatch
+	body          *regexp.Regexp
+	textOnly      bool
+	sender        *regexp.Regexp
+	after, before time.Time
+	larger        int64
+	smaller       int64
+	invert        bool
+}
+
+func main() {
+	var headers headerFlags
+	flag.Var(&headers, "H", "header predicate `Name=regexp` (repeatable)")
+	body := flag.String("e", "", "body `regexp`")
+	textOnly := flag.Bool("text", false, "match the body against decoded MIME text parts only")
+	sender := flag.String("sender", "", "envelope sender or From address `regexp`")
+	after := flag.String("after", "", "select messages dated on or after `YYYY-MM-DD`")
+	before := flag.String("before", "", "select messages dated before `YYYY-MM-DD`")
+	larger := flag.String("larger", "", "select messages larger than `size`")
+	smaller := flag.String("smaller", "", "select messages smaller than `size`")
+	ignoreCase := flag.Bool("i", false, "ignore case in regular expressions")
+	invert := flag.Bool("v", false, "select non-matching messages")
+	count := flag.Bool("c", false, "print only a count of matching messages")
+	output := flag.String("o", "", "write matching messages to mbox `file`")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mboxgrep: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	compile := func(expr string) *regexp.Regexp {
+		if expr == "" {
+			return nil
+		}
+		if *ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return re
+	}
+
+	size := func(s string) int64 {
+		if s == "" {
+			return -1
+		}
+		n, err := mbox.ParseSize(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return n
+	}
+
+	c := criteria{
+		body:     compile(*body),
+		textOnly: *textOnly,
+		sender:   compile(*sender),
+		after:    parseDate(*after),
+		before:   parseDate(*before),
+		larger:   size(*larger),
+		smaller:  size(*smaller),
+		invert:   *invert,
+	}
+	for _, h := range headers {
+		k := strings.Index(h, "=")
+		c.headers = append(c.headers, headerMatch{name: h[:k], re: compile(h[k+1:])})
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	s, err := mbox.CreateMboxStream(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var out *os.File
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	matches := 0
+	for n := 1; ; n++ {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		var raw bytes.Buffer
+		if _, err := msg.WriteTo(&raw); err != nil {
+			log.Fatal(err)
+		}
+		if c.match(msg, raw.Bytes()) == c.invert {
+			continue
+		}
+		matches++
+		switch {
+		case out != nil:
+			if _, err := out.Write(raw.Bytes()); err != nil {
+				log.Fatal(err)
+			}
+		case !*count:
+			summarize(n, msg, raw.Len())
+		}
+	}
+
+	if out != nil {
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *count {
+		fmt.Println(matches)
+	}
+	if matches == 0 {
+		os.Exit(1)
+	}
+}
+
+// match applies every predicate to the message, whose raw bytes are given.
+func (c *criteria) match(msg *mbox.Message, raw []byte) bool {
+	h := msg.MailHeader()
+	for _, hm := range c.headers {
+		found := false
+		for _, v := range h[textproto.CanonicalMIMEHeaderKey(hm.name)] {
+			if hm.re.MatchString(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if c.sender != nil && !c.sender.MatchString(msg.Sender()) && !c.sender.MatchString(h.Get("From")) {
+		return false
+	}
+
+	if c.larger >= 0 && int64(len(raw)) <= c.larger {
+		return false
+	}
+	if c.smaller >= 0 && int64(len(raw)) >= c.smaller {
+		return false
+	}
+
+	if !c.after.IsZero() || !c.before.IsZero() {
+		date := messageDate(msg)
+		if date.IsZero() || (!c.after.IsZero() && date.Before(c.after)) || (!c.before.IsZero() && !date.Before(c.before)) {
+			return false
+		}
+	}
+
+	if c.body != nil {
+		body := raw[len(msg.RawHeader()):]
+		if c.textOnly {
+			parts, _ := mbox.Parts(h, bytes.NewReader(body))
+			return c.body.MatchString(mbox.Text(parts))
+		}
+		return c.body.Match(body)
+	}
+	return true
+}
+
+// messageDate answers the message's Date header, falling back on its envelope.
+func messageDate(msg *mbox.Message) time.Time {
+	if d, err := msg.Date(); err == nil {
+		return d
+	}
+	env, _ := msg.Envelope()
+	return env.Date
+}
+
+// summarize prints one line describing a matching message.
+func summarize(n int, msg *mbox.Message, size int) {
+	h := msg.MailHeader()
+	date := ""
+	if d := messageDate(msg); !d.IsZero() {
+		date = d.Format("2006-01-02")
+	}
+	from := h.Get("From")
+	if from == "" {
+		env, _ := msg.Envelope()
+		from = env.Sender
+	}
+	fmt.Printf("%d\t%s\t%d\t%s\t%s\n", n, date, size, from, h.Get("Subject"))
+}
+
+func parseDate(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return t
+}
@@ -0,0 +1,92 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mboxlint checks mbox files for framing and header problems, and
+// optionally writes a repaired copy.
+//
+// Usage:
+//
+//	mboxlint [-strict] mailbox...
+//	mboxlint -fix output mailbox
+//
+// Each problem is printed as file:line: severity: kind: detail.  The exit
+// status is 1 if any error was found, or with -strict, any warning; it is 2 if
+// a mailbox couldn't be read at all.  With -fix, the problems are reported
+// likewise and a corrected mailbox, as produced by mbox.Repair, is written to
+// the output file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/sam-falvo/mbox"
+)
+
+func main() {
+	fix := flag.String("fix", "", "write a repaired copy of the mailbox to `file`")
+	strict := flag.Bool("strict", false, "treat warnings as errors")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mboxlint: ")
+
+	if flag.NArg() < 1 || *fix != "" && flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	report := func(name string) func(mbox.Problem) {
+		return func(p mbox.Problem) {
+			fmt.Printf("%s:%d: %s: %s: %s\n", name, p.Line, p.Severity, p.Kind, p.Detail)
+			if p.Severity == mbox.Error || *strict {
+				failed = true
+			}
+		}
+	}
+
+	if *fix != "" {
+		if err := repair(flag.Arg(0), *fix, report(flag.Arg(0))); err != nil {
+			log.Printf("%s: %v", flag.Arg(0), err)
+			os.Exit(2)
+		}
+	} else {
+		for _, name := range flag.Args() {
+			if err := validate(name, report(name)); err != nil {
+				log.Printf("%s: %v", name, err)
+				os.Exit(2)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func validate(name string, fn func(mbox.Problem)) error {
+	mm, err := mbox.OpenMapped(name)
+	if err != nil {
+		return err
+	}
+	defer mm.Close()
+	return mbox.Validate(mm.Bytes(), fn)
+}
+
+func repair(name, output string, fn func(mbox.Problem)) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err = mbox.Repair(in, out, fn); err != nil {
+		out.Close()
+		os.Remove(output)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,78 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mboxmerge merges several mbox files into one, sorted by date.
+//
+// Usage:
+//
+//	mboxmerge [-sort date|envelope] [-dialect mboxrd] [-dedupe message-id|header|body] [-mem 64M] -o output mailbox...
+//
+// Inputs too large to sort in memory are sorted externally, using temporary
+// files in $TMPDIR.  The output replaces any existing file only once it's
+// complete, so it may name one of the inputs.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sam-falvo/mbox"
+)
+
+func main() {
+	output := flag.String("o", "", "write the merged mailbox to `file`")
+	sortBy := flag.String("sort", "date", "sort by Date `header` (date) or envelope timestamp (envelope)")
+	dialect := flag.String("dialect", "mboxrd", "`dialect` of the merged mailbox")
+	dedupe := flag.String("dedupe", "", "drop duplicates by `key`: message-id, header or body")
+	mem := flag.String("mem", "64M", "memory `limit` before sorting externally")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mboxmerge: ")
+
+	if flag.NArg() == 0 || *output == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var opts mbox.MergeOptions
+	var err error
+	switch *sortBy {
+	case "date":
+		opts.SortBy = mbox.SortByDate
+	case "envelope":
+		opts.SortBy = mbox.SortByEnvelope
+	default:
+		log.Fatalf("unknown sort key %q", *sortBy)
+	}
+	if opts.Dialect, err = mbox.ParseDialect(*dialect); err != nil {
+		log.Fatal(err)
+	}
+	if *dedupe != "" {
+		opts.Dedupe = true
+		if opts.DedupeBy, err = mbox.ParseDedupeKey(*dedupe); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *mem != "" {
+		if opts.MaxMemory, err = mbox.ParseSize(*mem); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(*output), "."+filepath.Base(*output)+".tmp")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mbox.Merge(out, flag.Args(), &opts)
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), *output)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mboxsplit splits an mbox file into several smaller ones.  Messages
+// are never split, and are copied byte for byte.
+//
+// Usage:
+//
+//	mboxsplit -by count|size|year|month|domain|list [-n N] [-prefix path] mailbox
+//
+// With -by count, each output holds N messages; with -by size, each holds at
+// most N bytes (K, M and G suffixes are allowed).  Outputs are named
+// prefix-name.mbox, where prefix defaults to the input's name.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/sam-falvo/mbox"
+)
+
+func main() {
+	by := flag.String("by", "count", "split `rule`: count, size, year, month, domain or list")
+	n := flag.String("n", "1000", "messages (count) or bytes (size) per output `N`")
+	prefix := flag.String("prefix", "", "output file `prefix` (default: the input's name)")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mboxsplit: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *prefix == "" {
+		*prefix = flag.Arg(0)
+	}
+
+	var rule mbox.SplitRule
+	switch *by {
+	case "count":
+		count, err := strconv.Atoi(*n)
+		if err != nil || count < 1 {
+			log.Fatalf("bad message count %q", *n)
+		}
+		rule = mbox.SplitEvery(count)
+	case "size":
+		size, err := mbox.ParseSize(*n)
+		if err != nil || size < 1 {
+			log.Fatalf("bad size %q", *n)
+		}
+		rule = mbox.SplitBySize(size)
+	case "year":
+		rule = mbox.SplitByYear()
+	case "month":
+		rule = mbox.SplitByMonth()
+	case "domain":
+		rule = mbox.SplitBySenderDomain()
+	case "list":
+		rule = mbox.SplitByListID()
+	default:
+		log.Fatalf("unknown rule %q", *by)
+	}
+
+	in, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	s, err := mbox.CreateMboxStream(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	counts, err := mbox.Split(s, rule, func(name string, first bool) (io.WriteCloser, error) {
+		flags := os.O_WRONLY | os.O_APPEND
+		if first {
+			flags |= os.O_CREATE | os.O_EXCL
+		}
+		return os.OpenFile(*prefix+"-"+name+".mbox", flags, 0600)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s-%s.mbox\t%d\n", *prefix, name, counts[name])
+	}
+}
@@ -0,0 +1,263 @@
+// vim: ts=8 noexpandtab ai
+
+// Command mboxstat reports statistics about an mbox file, along with any
+// defects found in it.
+//
+// Usage:
+//
+//	mboxstat [-json] [-top N] mailbox
+//
+// The report covers the number of messages and their sizes, the range of
+// their dates, the most frequent senders and recipients, how often each header
+// field appears, the mailbox's likely dialect, and every problem reported by
+// mbox.Validate, with its line number.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A Count pairs a name with the number of times it was seen.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// A Bucket counts the messages no larger than Limit bytes, and larger than the
+// previous bucket's limit.  The last bucket has no limit.
+type Bucket struct {
+	Limit int64 `json:"limit,omitempty"`
+	Count int   `json:"count"`
+}
+
+// A Problem is an mbox.Problem as it appears in the JSON report.
+type Problem struct {
+	Line     int    `json:"line"`
+	Offset   int64  `json:"offset"`
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+	Detail   string `json:"detail"`
+}
+
+// A Report holds everything mboxstat finds.
+type Report struct {
+	Mailbox    string     `json:"mailbox"`
+	Dialect    string     `json:"dialect"`
+	Messages   int        `json:"messages"`
+	Bytes      int64      `json:"bytes"`
+	Smallest   int64      `json:"smallest"`
+	Largest    int64      `json:"largest"`
+	Mean       int64      `json:"mean"`
+	Median     int64      `json:"median"`
+	Sizes      []Bucket   `json:"sizes"`
+	Earliest   *time.Time `json:"earliest,omitempty"`
+	Latest     *time.Time `json:"latest,omitempty"`
+	Undated    int        `json:"undated"`
+	Senders    []Count    `json:"senders"`
+	Recipients []Count    `json:"recipients"`
+	Headers    []Count    `json:"headers"`
+	Problems   []Problem  `json:"problems"`
+}
+
+var bucketLimits = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 0}
+
+func main() {
+	asJSON := flag.Bool("json", false, "write the report as JSON")
+	top := flag.Int("top", 10, "list the `N` most frequent senders and recipients")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("mboxstat: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	mm, err := mbox.OpenMapped(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer mm.Close()
+
+	r, err := analyze(mm.Bytes(), *top)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Mailbox = flag.Arg(0)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	} else {
+		err = r.print()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// analyze gathers the report for a mailbox held in memory.
+func analyze(buf []byte, top int) (*Report, error) {
+	r := &Report{Sizes: make([]Bucket, len(bucketLimits))}
+	for i, limit := range bucketLimits {
+		r.Sizes[i].Limit = limit
+	}
+
+	d, err := mbox.DetectDialect(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	r.Dialect = d.String()
+
+	senders := make(map[string]int)
+	recipients := make(map[string]int)
+	headers := make(map[string]int)
+	var sizes []int64
+
+	s := mbox.NewScanner(buf)
+	for s.Next() {
+		v := s.View()
+		size := int64(len(v.Raw))
+		sizes = append(sizes, size)
+		r.Bytes += size
+		for i, limit := range bucketLimits {
+			if limit == 0 || size <= limit {
+				r.Sizes[i].Count++
+				break
+			}
+		}
+
+		h := v.MailHeader()
+		for name := range h {
+			headers[name]++
+		}
+		if date, err := h.Date(); err == nil {
+			if r.Earliest == nil || date.Before(*r.Earliest) {
+				r.Earliest = &date
+			}
+			if r.Latest == nil || date.After(*r.Latest) {
+				r.Latest = &date
+			}
+		} else {
+			r.Undated++
+		}
+		if from, err := h.AddressList("From"); err == nil && len(from) > 0 {
+			senders[strings.ToLower(from[0].Address)]++
+		} else {
+			senders[string(v.Sender())]++
+		}
+		for _, key := range []string{"To", "Cc"} {
+			list, _ := h.AddressList(key)
+			for _, a := range list {
+				recipients[strings.ToLower(a.Address)]++
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	r.Messages = len(sizes)
+	if r.Messages > 0 {
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+		r.Smallest = sizes[0]
+		r.Largest = sizes[len(sizes)-1]
+		r.Mean = r.Bytes / int64(len(sizes))
+		r.Median = sizes[len(sizes)/2]
+	}
+	r.Senders = ranked(senders, top)
+	r.Recipients = ranked(recipients, top)
+	r.Headers = ranked(headers, 0)
+
+	r.Problems = []Problem{}
+	err = mbox.ValidateDialect(buf, d, func(p mbox.Problem) {
+		r.Problems = append(r.Problems, Problem{p.Line, p.Offset, p.Kind.String(), p.Severity.String(), p.Detail})
+	})
+	return r, err
+}
+
+// ranked orders the counts from most to least frequent, breaking ties by
+// name, and keeps at most n of them; zero keeps them all.
+func ranked(counts map[string]int, n int) []Count {
+	list := make([]Count, 0, len(counts))
+	for name, c := range counts {
+		list = append(list, Count{name, c})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// print writes the report in human-readable form to standard output.
+func (r *Report) print() error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Mailbox:     %s\n", r.Mailbox)
+	fmt.Fprintf(&b, "Dialect:     %s (guessed)\n", r.Dialect)
+	fmt.Fprintf(&b, "Messages:    %d\n", r.Messages)
+	fmt.Fprintf(&b, "Total size:  %d bytes\n", r.Bytes)
+	if r.Messages > 0 {
+		fmt.Fprintf(&b, "Message size: smallest %d, largest %d, mean %d, median %d bytes\n", r.Smallest, r.Largest, r.Mean, r.Median)
+		for i, bucket := range r.Sizes {
+			switch {
+			case bucket.Limit == 0:
+				fmt.Fprintf(&b, "  > %-10s %d\n", human(r.Sizes[i-1].Limit), bucket.Count)
+			default:
+				fmt.Fprintf(&b, "  <= %-9s %d\n", human(bucket.Limit), bucket.Count)
+			}
+		}
+	}
+	if r.Earliest != nil {
+		fmt.Fprintf(&b, "Dates:       %s to %s\n", r.Earliest.Format(time.RFC1123Z), r.Latest.Format(time.RFC1123Z))
+	}
+	if r.Undated > 0 {
+		fmt.Fprintf(&b, "Undated:     %d\n", r.Undated)
+	}
+	printCounts(&b, "Top senders", r.Senders)
+	printCounts(&b, "Top recipients", r.Recipients)
+	printCounts(&b, "Header fields", r.Headers)
+	fmt.Fprintf(&b, "Problems:    %d\n", len(r.Problems))
+	for _, p := range r.Problems {
+		fmt.Fprintf(&b, "  line %d: %s: %s: %s\n", p.Line, p.Severity, p.Kind, p.Detail)
+	}
+	_, err := os.Stdout.WriteString(b.String())
+	return err
+}
+
+func printCounts(b *strings.Builder, title string, counts []Count) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s:\n", title)
+	for _, c := range counts {
+		fmt.Fprintf(b, "  %7d  %s\n", c.Count, c.Name)
+	}
+}
+
+// human renders a size limit with a binary suffix.
+func human(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%dM", n>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,240 @@
+// vim: ts=8 noexpandtab ai
+
+// Command takeoutsplit splits a Gmail Takeout mailbox into one mailbox, or
+// Maildir, per label.
+//
+// Usage:
+//
+//	takeoutsplit [-dir output] [-maildir] [-skip labels] [-dialect d] mailbox
+//
+// A message with several labels is copied into each of their folders, and a
+// message with none goes into Unlabeled.  Nested labels, such as
+// Work/Projects, become nested directories.  Labels listed by -skip, which
+// by default names Gmail's Unread and Opened markers, get no folder.  Should
+// two labels make the same folder name, once characters unsafe in file names
+// are replaced, the later one gets a numeric suffix.  Maildir output records
+// the Unread and Starred labels as Maildir flags.
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sam-falvo/mbox"
+	"github.com/sam-falvo/mbox/gmail"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory in which to create folders")
+	maildir := flag.Bool("maildir", false, "write Maildir folders rather than mbox files")
+	skip := flag.String("skip", "Unread,Opened", "comma-separated `labels` which get no folder")
+	dialect := flag.String("dialect", "auto", "dialect of the mailbox, or auto to detect it")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("takeoutsplit: ")
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	skipped := make(map[string]bool)
+	for _, l := range strings.Split(*skip, ",") {
+		skipped[strings.ToLower(strings.TrimSpace(l))] = true
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	d, err := mbox.ParseDialect(*dialect)
+	if *dialect == "auto" {
+		d, err = mbox.DetectDialectAt(f)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := mbox.CreateMboxStream(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	folders := &folders{dir: *dir}
+	maildirs := make(map[string]*mbox.Maildir)
+	counts := make(map[string]int)
+	err = gmail.ForEachLabel(s, func(label string, v *mbox.View) error {
+		if skipped[strings.ToLower(label)] {
+			return nil
+		}
+		if label == "" {
+			label = "Unlabeled"
+		}
+		counts[label]++
+
+		if !*maildir {
+			out, err := folders.mbox(label)
+			if err != nil {
+				return err
+			}
+			_, err = out.Write(v.Raw)
+			return err
+		}
+
+		var err error
+		md, ok := maildirs[label]
+		if !ok {
+			if md, err = mbox.CreateMaildir(folders.path(label, "")); err != nil {
+				return err
+			}
+			maildirs[label] = md
+		}
+		var eml bytes.Buffer
+		if err = v.WriteEML(&eml, d); err != nil {
+			return err
+		}
+		if flags := gmail.MaildirFlags(gmail.Labels(v.MailHeader())); flags != "" {
+			_, err = md.Store(&eml, flags)
+		} else {
+			_, err = md.Deliver(&eml)
+		}
+		return err
+	})
+	if e := folders.close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	labels := make([]string, 0, len(counts))
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Printf("%7d  %s\n", counts[label], label)
+	}
+}
+
+// maxOpen bounds how many mbox files takeoutsplit keeps open at once.  A
+// Takeout export may carry thousands of labels.
+const maxOpen = 64
+
+// folders chooses a folder for each label, and keeps the most recently used
+// mbox files open.
+type folders struct {
+	dir    string
+	paths  map[string]string        // each label's folder
+	taken  map[string]bool          // folders in use, in lower case
+	open   map[string]*list.Element // open files by label
+	recent list.List                // of *openMbox, most recent first
+}
+
+type openMbox struct {
+	label string
+	f     *os.File
+}
+
+// path answers the label's folder, choosing it the first time it's asked for:
+// the label as folderPath renders it, with a numeric suffix if another label
+// already took that name.  If ext is given, the name is also passed over while
+// a file with that extension already exists; Maildir folders, which take no
+// extension, may instead be added to.
+func (fs *folders) path(label, ext string) string {
+	if path, ok := fs.paths[label]; ok {
+		return path
+	}
+	if fs.paths == nil {
+		fs.paths, fs.taken = make(map[string]string), make(map[string]bool)
+	}
+	base := filepath.Join(fs.dir, folderPath(label))
+	path := base
+	for n := 2; fs.taken[strings.ToLower(path)] || ext != "" && exists(path+ext); n++ {
+		path = fmt.Sprintf("%s-%d", base, n)
+	}
+	if path != base {
+		log.Printf("%s: writing to %s, since %s is taken", label, path+ext, base+ext)
+	}
+	fs.paths[label], fs.taken[strings.ToLower(path)] = path, true
+	return path
+}
+
+// exists answers true if something already exists at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// mbox answers the open mbox file for the label, creating it the first time,
+// and reopening it to append if it's been closed to make way for others.
+func (fs *folders) mbox(label string) (*os.File, error) {
+	if e, ok := fs.open[label]; ok {
+		fs.recent.MoveToFront(e)
+		return e.Value.(*openMbox).f, nil
+	}
+	if fs.recent.Len() >= maxOpen {
+		last := fs.recent.Remove(fs.recent.Back()).(*openMbox)
+		delete(fs.open, last.label)
+		if err := last.f.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, created := fs.paths[label]
+	path := fs.path(label, ".mbox") + ".mbox"
+	flags := os.O_WRONLY | os.O_APPEND
+	if !created {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		flags |= os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if fs.open == nil {
+		fs.open = make(map[string]*list.Element)
+	}
+	fs.open[label] = fs.recent.PushFront(&openMbox{label: label, f: f})
+	return f, nil
+}
+
+// close closes every open mbox file.
+func (fs *folders) close() (err error) {
+	for el := fs.recent.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*openMbox).f.Close(); err == nil {
+			err = e
+		}
+	}
+	fs.recent.Init()
+	fs.open = nil
+	return err
+}
+
+// folderPath turns a label into a relative path, one directory per level of
+// nesting, with each component made safe for use as a file name.
+func folderPath(label string) string {
+	var parts []string
+	for _, p := range strings.Split(label, "/") {
+		p = strings.Map(func(r rune) rune {
+			if r < ' ' || strings.ContainsRune(`\:*?"<>|`, r) {
+				return '_'
+			}
+			return r
+		}, strings.TrimSpace(p))
+		if p == "" || p == "." || p == ".." {
+			p = "_"
+		}
+		parts = append(parts, p)
+	}
+	return filepath.Join(parts...)
+}
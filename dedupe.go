@@ -0,0 +1,122 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// A DedupeKey selects what makes two messages duplicates of each other.
+type DedupeKey int
+
+const (
+	// ByMessageID treats messages sharing a Message-ID as duplicates.
+	// Messages without one are never considered duplicates.
+	ByMessageID DedupeKey = iota
+
+	// ByHeaderHash treats messages as duplicates if their From, To, Cc,
+	// Subject, Date and Message-ID headers agree, after decoding and
+	// normalizing case and whitespace.  Messages lacking all of these
+	// headers are never considered duplicates.
+	ByHeaderHash
+
+	// ByBodyHash treats messages with identical bodies as duplicates,
+	// ignoring differences in line terminators, trailing blank lines, and
+	// the escaping of the mailboxes they're stored in.
+	ByBodyHash
+)
+
+var dedupeKeyNames = []string{"message-id", "header", "body"}
+
+// String answers the name of the key, as accepted by ParseDedupeKey.
+func (k DedupeKey) String() string {
+	if k < 0 || int(k) >= len(dedupeKeyNames) {
+		return fmt.Sprintf("DedupeKey(%d)", int(k))
+	}
+	return dedupeKeyNames[k]
+}
+
+// ParseDedupeKey converts the name of a key, as produced by String(), back
+// into a DedupeKey.
+func ParseDedupeKey(name string) (DedupeKey, error) {
+	for i, n := range dedupeKeyNames {
+		if strings.EqualFold(n, name) {
+			return DedupeKey(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown deduplication key %q", name)
+}
+
+// dedupeHeaders lists the headers compared by ByHeaderHash.
+var dedupeHeaders = []string{"From", "To", "Cc", "Subject", "Date", "Message-Id"}
+
+// A Deduper remembers the messages it has seen, so as to recognize
+// duplicates.  Messages are numbered from zero in the order they are checked.
+type Deduper struct {
+	key  DedupeKey
+	seen map[string]int
+	n    int
+}
+
+// NewDeduper creates a Deduper comparing messages by the given key.
+func NewDeduper(key DedupeKey) *Deduper {
+	return &Deduper{key: key, seen: make(map[string]int)}
+}
+
+// Check records a message, whose body has already been read, and reports
+// whether it duplicates one checked earlier.  If so, it also answers the
+// number of that earlier message.  The body is given as stored in a mailbox of
+// the given dialect.
+func (d *Deduper) Check(msg *Message, body []byte, dialect Dialect) (first int, dup bool) {
+	n := d.n
+	d.n++
+
+	k := d.keyOf(msg, body, dialect)
+	if k == "" {
+		return n, false
+	}
+	if first, dup = d.seen[k]; dup {
+		return first, true
+	}
+	d.seen[k] = n
+	return n, false
+}
+
+// keyOf answers the string identifying the message for the chosen key, or an
+// empty string if the message cannot be identified.
+func (d *Deduper) keyOf(msg *Message, body []byte, dialect Dialect) string {
+	switch d.key {
+	case ByMessageID:
+		return msg.MessageID()
+
+	case ByHeaderHash:
+		h := sha256.New()
+		hdr := msg.MailHeader()
+		empty := true
+		for _, name := range dedupeHeaders {
+			for _, v := range hdr[name] {
+				v = strings.ToLower(strings.Join(strings.Fields(DecodeHeader(v)), " "))
+				empty = empty && v == ""
+				fmt.Fprintf(h, "%s:%s\n", name, v)
+			}
+		}
+		if empty {
+			return ""
+		}
+		return string(h.Sum(nil))
+
+	case ByBodyHash:
+		var b []byte
+		forEachLine(body, func(line []byte) {
+			b = append(b, dialect.unescapeLine(line)...)
+		})
+		b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+		b = bytes.TrimRight(b, "\n")
+		sum := sha256.Sum256(b)
+		return string(sum[:])
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const mboxWithDuplicates = `From a@bar.com
+Message-ID: <one@bar.com>
+Subject: Hello
+
+Body one.
+
+From a@bar.com
+Message-ID: <one@bar.com>
+Subject:   hello
+
+Body one.
+From b@bar.com
+Message-ID: <two@bar.com>
+Subject: Hello
+
+Body one.
+
+From c@bar.com
+Subject: No identifier
+
+Body three.
+`
+
+const mboxWithoutIdentifyingHeaders = `From a@bar.com
+X-Mailer: Nothing
+
+Body one.
+
+From a@bar.com
+Subject:
+X-Mailer: Nothing
+
+Body two.
+`
+
+// dedupeResults checks each message of the mailbox with a new Deduper,
+// answering "-" for each unique message and the number of the first copy
+// for each duplicate.
+func dedupeResults(t *testing.T, name, mailbox string, key DedupeKey) string {
+	d := NewDeduper(key)
+	var got []string
+	withOpenMboxStream(t, name, mailbox, func(mr *MboxStream) {
+		for {
+			msg, err := mr.ReadMessage()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(msg.BodyReader())
+			if first, dup := d.Check(msg, body, Mboxo); dup {
+				got = append(got, string(rune('0'+first)))
+			} else {
+				got = append(got, "-")
+			}
+		}
+	})
+	return strings.Join(got, ",")
+}
+
+// Given a mailbox with duplicated messages
+// When I check each message by each key
+// Then I expect the duplicates each key recognizes.
+func TestDeduper10(t *testing.T) {
+	expected := map[DedupeKey]string{
+		ByMessageID:  "-,0,-,-",
+		ByHeaderHash: "-,0,-,-",
+		ByBodyHash:   "-,0,0,-",
+	}
+	for key, want := range expected {
+		if got := dedupeResults(t, "TestDeduper10", mboxWithDuplicates, key); got != want {
+			t.Errorf("%v: expected %s; got %s", key, want, got)
+		}
+	}
+}
+
+// Given distinct messages lacking every header ByHeaderHash compares
+// When I check them by header
+// Then I expect neither taken for a duplicate.
+func TestDeduper20(t *testing.T) {
+	if got := dedupeResults(t, "TestDeduper20", mboxWithoutIdentifyingHeaders, ByHeaderHash); got != "-,-" {
+		t.Errorf("Expected -,-; got %s", got)
+	}
+}
+
+// Given the same message stored in an mboxcl2 mailbox and an mboxrd one
+// When I check both by body
+// Then I expect the second taken for a duplicate of the first.
+func TestDeduper30(t *testing.T) {
+	stored := map[Dialect]string{
+		Mboxcl2: "From a@bar.com\nSubject: x\nContent-Length: 16\n\n>From there\nend\n",
+		Mboxrd:  "From a@bar.com\nSubject: x\n\n>>From there\nend\n\n",
+	}
+	d := NewDeduper(ByBodyHash)
+	var got []bool
+	for _, dialect := range []Dialect{Mboxcl2, Mboxrd} {
+		withOpenMboxStream(t, "TestDeduper30", stored[dialect], func(mr *MboxStream) {
+			msg, err := mr.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(msg.BodyReader())
+			_, dup := d.Check(msg, body, dialect)
+			got = append(got, dup)
+		})
+	}
+	if len(got) != 2 || got[0] || !got[1] {
+		t.Error("Expected only the second copy taken for a duplicate; got ", got)
+	}
+}
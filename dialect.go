@@ -0,0 +1,225 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Dialect identifies one of the members of the MBOX family.  The dialects
+// differ in how they escape body lines which would otherwise be mistaken for
+// From markers, and in whether they rely upon Content-Length headers.
+type Dialect int
+
+const (
+	// Mboxo escapes body lines starting with "From " by prefixing a '>'.
+	// The escaping cannot be reversed unambiguously.
+	Mboxo Dialect = iota
+
+	// Mboxrd escapes body lines matching /^>*From / by prefixing a '>',
+	// which lets readers reverse the escaping exactly.
+	Mboxrd
+
+	// Mboxcl escapes as Mboxo does, but also records the length of each
+	// body in a Content-Length header.
+	Mboxcl
+
+	// Mboxcl2 records Content-Length headers and performs no escaping at
+	// all.
+	Mboxcl2
+)
+
+var dialectNames = []string{"mboxo", "mboxrd", "mboxcl", "mboxcl2"}
+
+// String answers the conventional name of the dialect.
+func (d Dialect) String() string {
+	if d < 0 || int(d) >= len(dialectNames) {
+		return fmt.Sprintf("Dialect(%d)", int(d))
+	}
+	return dialectNames[d]
+}
+
+// ParseDialect converts a dialect's conventional name, as produced by
+// String(), back into a Dialect.
+func ParseDialect(name string) (Dialect, error) {
+	for i, n := range dialectNames {
+		if strings.EqualFold(n, name) {
+			return Dialect(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown mbox dialect %q", name)
+}
+
+// usesContentLength answers true for dialects which record Content-Length
+// headers.
+func (d Dialect) usesContentLength() bool {
+	return d == Mboxcl || d == Mboxcl2
+}
+
+// escapeLine answers the line as it must be written into the body of a
+// message in the given dialect.
+func (d Dialect) escapeLine(line []byte) []byte {
+	switch d {
+	case Mboxo, Mboxcl:
+		if isFromLine(line) {
+			return append([]byte{'>'}, line...)
+		}
+	case Mboxrd:
+		if isFromLine(bytes.TrimLeft(line, ">")) {
+			return append([]byte{'>'}, line...)
+		}
+	}
+	return line
+}
+
+// unescapeLine reverses escapeLine.  For Mboxo and Mboxcl, this necessarily
+// also alters lines which genuinely started with ">From ".
+func (d Dialect) unescapeLine(line []byte) []byte {
+	switch d {
+	case Mboxo, Mboxcl:
+		if len(line) > 0 && line[0] == '>' && isFromLine(line[1:]) {
+			return line[1:]
+		}
+	case Mboxrd:
+		if len(line) > 0 && line[0] == '>' && isFromLine(bytes.TrimLeft(line, ">")) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+// DetectDialect reads an mbox file and guesses which dialect wrote it.
+// Content-Length headers indicate Mboxcl or Mboxcl2, distinguished by the
+// presence of unescaped or escaped From lines in the bodies they delimit, but
+// only if every message carries one which correctly delimits its body; a
+// mailbox which merely contains the odd Content-Length header still needs its
+// From lines escaped.  Otherwise, body
+// lines bearing more than one '>' before "From " indicate Mboxrd.  Lacking any
+// evidence either way, DetectDialect answers Mboxo, the most conservative
+// choice.
+func DetectDialect(r io.Reader) (Dialect, error) {
+	return detectDialect(r, false)
+}
+
+// detectDialect implements DetectDialect.  If partial is true, the reader
+// yields only the start of a mailbox, so a body cut short by the end of input
+// doesn't count against its Content-Length.
+func detectDialect(r io.Reader, partial bool) (Dialect, error) {
+	var (
+		quoted, doubleQuoted bool
+		bare                 bool // a From line lies in a delimited body
+		messages             int
+		unframed             bool // some message lacks a reliable Content-Length
+		inHeader             bool
+		length               int  // the current message's Content-Length, or -1
+		remaining            int  // bytes left in a body delimited by Content-Length
+		ended                bool // such a body has just ended
+		separated            bool // and a blank line has followed it
+	)
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadSlice('\n')
+		size := len(line)
+		if err == bufio.ErrBufferFull {
+			// Skip the remainder of overlong lines; they're body text.
+			for err == bufio.ErrBufferFull {
+				var more []byte
+				more, err = br.ReadSlice('\n')
+				size += len(more)
+			}
+			line = nil
+		}
+		if err != nil && err != io.EOF {
+			return Mboxo, err
+		}
+
+		switch {
+		case size == 0:
+		case remaining >= size:
+			remaining -= size
+			ended = remaining == 0
+			bare = bare || isFromLine(line)
+			quoted, doubleQuoted = noteQuoting(line, quoted, doubleQuoted)
+			line = nil
+		case remaining > 0:
+			// The body runs past its Content-Length.
+			remaining, unframed = 0, true
+		case ended && isBlankLine(line) && !separated:
+			separated = true
+			line = nil
+		case ended && !isFromLine(line):
+			ended, unframed = false, true
+		}
+
+		switch {
+		case line == nil:
+		case isFromLine(line):
+			messages++
+			inHeader, length, ended, separated = true, -1, false, false
+		case inHeader && isBlankLine(line):
+			inHeader = false
+			switch {
+			case length < 0:
+				unframed = true
+			case length == 0:
+				ended = true
+			default:
+				remaining = length
+			}
+		case inHeader:
+			if len(line) > 15 && equalFold(line[:15], "Content-Length:") {
+				if n, ok := parseDecimal(bytes.TrimSpace(line[15:])); ok {
+					length = n
+				}
+			}
+		default:
+			quoted, doubleQuoted = noteQuoting(line, quoted, doubleQuoted)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	if (remaining > 0 || inHeader) && !partial {
+		unframed = true
+	}
+
+	switch {
+	case messages > 0 && !unframed && quoted && !bare:
+		return Mboxcl, nil
+	case messages > 0 && !unframed:
+		return Mboxcl2, nil
+	case doubleQuoted:
+		return Mboxrd, nil
+	}
+	return Mboxo, nil
+}
+
+// noteQuoting updates the evidence DetectDialect gathers from a body line.
+func noteQuoting(line []byte, quoted, doubleQuoted bool) (bool, bool) {
+	if len(line) > 0 && line[0] == '>' {
+		rest := bytes.TrimLeft(line, ">")
+		if isFromLine(rest) {
+			quoted = true
+			doubleQuoted = doubleQuoted || len(line)-len(rest) > 1
+		}
+	}
+	return quoted, doubleQuoted
+}
+
+// detectLimit bounds how much of a mailbox DetectDialectAt inspects.
+const detectLimit = 4 << 20
+
+// DetectDialectAt works like DetectDialect, but inspects only the first few
+// megabytes of the mailbox, leaving any file offset undisturbed.  An empty
+// mailbox is taken to be Mboxo.
+func DetectDialectAt(r io.ReaderAt) (Dialect, error) {
+	var b [1]byte
+	n, _ := r.ReadAt(b[:], detectLimit)
+	return detectDialect(io.NewSectionReader(r, 0, detectLimit), n > 0)
+}
@@ -1,5 +1,5 @@
-// The mbox package provides support for reading legacy MBOX-family files.
-// Support for writing MBOX files remains unimplemented at this time.
+// The mbox package provides support for reading and writing legacy
+// MBOX-family files.
 //
 // According to Wikipedia (https://en.wikipedia.org/wiki/Mbox), at least four
 // different kinds of mutually incompatible MBOX formats exist:
@@ -11,7 +11,7 @@
 //
 // This mbox package should be able to handle all four kinds of mailbox files;
 // however, due to the vaguarities of the MBOX family, some of the burden for
-// proper decoding must rest with your client software.  For example, mbox
+// proper decoding must rest with your client software.  For example, MboxStream
 // ignores any Content-Length MIME headers, and performs absolutely minimal
 // amounts of processing of the headers so as to not discard potentially useful
 // information.  It further will not reverse any >From-escaping that might have
@@ -21,6 +21,20 @@
 // that to the CreateMboxStream() function.  Then, for each message in the MBOX
 // file, read that message and process as appropriate.
 //
-// Note: at this time, no means of seeking through the file exists.  Your
-// software must process messages in a sequential, batch-oriented manner.
+// Large mailboxes held in an io.ReaderAt may instead be handed to
+// ReadParallel, which parses independent chunks of the file on several CPU
+// cores at once.  When the whole file is already in memory, a Scanner splits
+// it into Views which reference the buffer directly, without copying; unlike
+// MboxStream, the Scanner honors Content-Length headers where they prove
+// reliable.
+//
+// MboxStream itself only reads sequentially.  To revisit a message later,
+// remember its Offset() and hand it to CreateMboxStreamAt().  Alternatively,
+// OpenMapped() maps an entire file into memory (on Linux, using mmap) and
+// offers each message by index or offset without further copying.
+//
+// Writing goes through a Writer, which produces any of the four dialects.  To
+// add a message to a mailbox which other programs may be reading or delivering
+// to at the same time, use Append(), which locks the file as procmail and mutt
+// expect.
 package mbox
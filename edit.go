@@ -0,0 +1,283 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An Editor deletes and replaces messages in an existing mailbox.  Changes
+// accumulate in memory until Commit, which rewrites the mailbox atomically:
+// the new contents are written to a temporary file in the same directory,
+// flushed to stable storage, and renamed over the original.  The new file
+// keeps the original's permissions and, where allowed, its owner.  Messages
+// left untouched are copied byte for byte.
+//
+// The mailbox remains locked, as described by LockMailbox, from OpenEditor
+// until Close.
+type Editor struct {
+	path    string
+	f       *os.File
+	lock    *MailboxLock
+	data    []byte
+	views   []View
+	dialect Dialect
+	edits   map[int]*edit
+}
+
+// An edit records the fate of a single message: deleted outright when
+// content is nil, or replaced by new content otherwise.
+type edit struct {
+	env     Envelope
+	content []byte
+}
+
+// OpenEditor locks the named mailbox and reads it in preparation for editing.
+func OpenEditor(path string) (*Editor, error) {
+	return OpenEditorTimeout(path, DefaultLockTimeout)
+}
+
+// OpenEditorTimeout works like OpenEditor, but waits at most timeout for
+// other processes to release the mailbox, failing with ErrLockTimeout.
+func OpenEditorTimeout(path string, timeout time.Duration) (*Editor, error) {
+	f, lock, err := openLocked(path, os.O_RDWR, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Editor{path: path, f: f, lock: lock, edits: make(map[int]*edit)}
+	if err = e.load(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// load reads the mailbox and indexes its messages.
+func (e *Editor) load() (err error) {
+	if e.data, err = io.ReadAll(io.NewSectionReader(e.f, 0, 1<<62)); err != nil {
+		return
+	}
+	if e.dialect, err = DetectDialectAt(bytes.NewReader(e.data)); err != nil {
+		return
+	}
+	s := NewScanner(e.data)
+	for s.Next() {
+		e.views = append(e.views, *s.View())
+	}
+	return s.Err()
+}
+
+// Len answers the number of messages in the mailbox as it was opened.
+// Deleting or replacing messages doesn't change their indices.
+func (e *Editor) Len() int {
+	return len(e.views)
+}
+
+// Message answers the original form of the i'th message, counting from zero.
+func (e *Editor) Message(i int) *View {
+	return &e.views[i]
+}
+
+// Dialect answers the dialect detected for the mailbox.  Replacement messages
+// are written in this dialect.
+func (e *Editor) Dialect() Dialect {
+	return e.dialect
+}
+
+// Find answers the index of the first message whose Message-ID header matches
+// the one given, or -1 if none does.  Angle brackets are optional.
+func (e *Editor) Find(messageID string) int {
+	want := trimAngles([]byte(messageID))
+	for i := range e.views {
+		if bytes.Equal(trimAngles(e.views[i].Get("Message-ID")), want) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Deleted answers true if the i'th message is marked for deletion.
+func (e *Editor) Deleted(i int) bool {
+	ed, ok := e.edits[i]
+	return ok && ed.content == nil
+}
+
+// Delete marks the i'th message for deletion.
+func (e *Editor) Delete(i int) error {
+	if err := e.checkIndex(i); err != nil {
+		return err
+	}
+	e.edits[i] = &edit{}
+	return nil
+}
+
+// Undelete forgets any deletion or replacement of the i'th message.
+func (e *Editor) Undelete(i int) error {
+	if err := e.checkIndex(i); err != nil {
+		return err
+	}
+	delete(e.edits, i)
+	return nil
+}
+
+// Replace arranges for the i'th message to be replaced with new content,
+// which must be an RFC 5322 message.
+func (e *Editor) Replace(i int, env Envelope, content io.Reader) error {
+	if err := e.checkIndex(i); err != nil {
+		return err
+	}
+	raw, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		raw = []byte{}
+	}
+	e.edits[i] = &edit{env: env, content: raw}
+	return nil
+}
+
+// DeleteByID marks the message with the given Message-ID for deletion.
+func (e *Editor) DeleteByID(messageID string) error {
+	i := e.Find(messageID)
+	if i < 0 {
+		return fmt.Errorf("No message with Message-ID %s", messageID)
+	}
+	return e.Delete(i)
+}
+
+// ReplaceByID replaces the message with the given Message-ID.
+func (e *Editor) ReplaceByID(messageID string, env Envelope, content io.Reader) error {
+	i := e.Find(messageID)
+	if i < 0 {
+		return fmt.Errorf("No message with Message-ID %s", messageID)
+	}
+	return e.Replace(i, env, content)
+}
+
+func (e *Editor) checkIndex(i int) error {
+	if i < 0 || i >= len(e.views) {
+		return fmt.Errorf("No such message: %d", i)
+	}
+	return nil
+}
+
+// Commit rewrites the mailbox with all pending changes applied.  The editor
+// remains open afterwards, reflecting the new contents, so further changes may
+// be made.  If no changes are pending, Commit does nothing.
+func (e *Editor) Commit() (err error) {
+	if len(e.edits) == 0 {
+		return nil
+	}
+
+	fi, err := e.f.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), "."+filepath.Base(e.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, e.dialect)
+	for i := range e.views {
+		ed, ok := e.edits[i]
+		switch {
+		case !ok:
+			buf.Write(e.views[i].Raw)
+		case ed.content != nil:
+			if err = w.WriteMessage(ed.env, bytes.NewReader(ed.content)); err != nil {
+				return
+			}
+		}
+	}
+
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		return
+	}
+	if err = tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return
+	}
+	if err = copyOwner(tmp, fi); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), e.path); err != nil {
+		return
+	}
+	syncDir(filepath.Dir(e.path))
+
+	// Our kernel locks still refer to the old file.  Move them to the new
+	// one, keeping the dotlock throughout, so we continue to hold the
+	// mailbox.
+	f, err := os.OpenFile(e.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err = e.lock.moveTo(f, DefaultLockTimeout); err != nil {
+		f.Close()
+		return err
+	}
+	e.f.Close()
+	e.f = f
+
+	e.data, e.views, e.edits = nil, nil, make(map[int]*edit)
+	return e.load()
+}
+
+// Refresh keeps the mailbox's dotlock from going stale, as described by
+// MailboxLock.Refresh.  Editors held open for more than a few minutes must
+// call it periodically.
+func (e *Editor) Refresh() error {
+	return e.lock.Refresh()
+}
+
+// Close releases the mailbox, discarding any changes not yet committed.
+func (e *Editor) Close() error {
+	var err error
+	if e.lock != nil {
+		err = e.lock.Unlock()
+		e.lock = nil
+	}
+	if e.f != nil {
+		if e2 := e.f.Close(); err == nil {
+			err = e2
+		}
+		e.f = nil
+	}
+	return err
+}
+
+// syncDir flushes a directory's entries to stable storage, so a rename within
+// it survives a crash.  Failures are ignored, since not every platform permits
+// syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// trimAngles removes surrounding whitespace and angle brackets from a message
+// identifier.
+func trimAngles(id []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimPrefix(bytes.TrimSpace(id), []byte("<")), []byte(">"))
+}
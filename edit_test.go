@@ -0,0 +1,155 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mboxWithMessageIDs = `From a@bar.com
+Message-ID: <one@bar.com>
+Subject: One
+
+First.
+
+From b@bar.com
+Message-ID: <two@bar.com>
+Subject: Two
+
+Second.
+>From the archives.
+
+From c@bar.com
+Message-ID: <three@bar.com>
+Subject: Three
+
+Third.
+`
+
+// withEditor sets up a test.  It writes the given mailbox to a temporary file
+// and opens an Editor on it.
+func withEditor(t *testing.T, source string, test func(path string, e *Editor)) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(source), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := OpenEditor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	test(path, e)
+}
+
+// Given a mailbox with three messages
+// When I delete the first by index and replace the last by Message-ID
+// Then I expect the untouched message to survive byte for byte.
+func TestEditor10(t *testing.T) {
+	withEditor(t, mboxWithMessageIDs, func(path string, e *Editor) {
+		second := string(e.Message(1).Raw)
+		if err := e.Delete(0); err != nil {
+			t.Fatal(err)
+		}
+		replacement := "Message-ID: <three@bar.com>\nSubject: Three, revised\n\nThird, again.\n"
+		if err := e.ReplaceByID("three@bar.com", testEnvelope, strings.NewReader(replacement)); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Commit(); err != nil {
+			t.Fatal("TestEditor10: ", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := second + testEnvelope.String() + "\n" + replacement + "\n"
+		if string(data) != expected {
+			t.Errorf("Expected %q; got %q", expected, data)
+		}
+		if e.Len() != 2 {
+			t.Error("Editor should reflect the committed mailbox; got ", e.Len(), " messages")
+		}
+	})
+}
+
+// Given a mailbox
+// When I delete a message but close without committing
+// Then I expect the mailbox to remain unchanged and unlocked.
+func TestEditor20(t *testing.T) {
+	withEditor(t, mboxWithMessageIDs, func(path string, e *Editor) {
+		if err := e.DeleteByID("<two@bar.com>"); err != nil {
+			t.Fatal(err)
+		}
+		if !e.Deleted(1) {
+			t.Error("Second message should be marked deleted")
+		}
+		if err := e.DeleteByID("missing@bar.com"); err == nil {
+			t.Error("Expected error deleting an unknown Message-ID")
+		}
+		e.Close()
+
+		data, _ := os.ReadFile(path)
+		if string(data) != mboxWithMessageIDs {
+			t.Error("Mailbox should be unchanged without Commit")
+		}
+		if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+			t.Error("Dotlock should be removed on Close")
+		}
+	})
+}
+
+// Given a mailbox open in an editor
+// When another editor and an appender wait for it while the first commits
+// Then I expect every change to be kept, none applied to the replaced file.
+func TestEditor30(t *testing.T) {
+	withEditor(t, mboxWithMessageIDs, func(path string, e *Editor) {
+		done := make(chan error, 2)
+		go func() {
+			done <- Append(path, testEnvelope, strings.NewReader(rfc5322Message))
+		}()
+		go func() {
+			e2, err := OpenEditor(path)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer e2.Close()
+			if err := e2.DeleteByID("<two@bar.com>"); err != nil {
+				done <- err
+				return
+			}
+			done <- e2.Commit()
+		}()
+		time.Sleep(3 * lockRetryInterval)
+
+		if err := e.DeleteByID("<one@bar.com>"); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Commit(); err != nil {
+			t.Fatal("TestEditor30: ", err)
+		}
+		e.Close()
+		for i := 0; i < 2; i++ {
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var subjects []string
+		s := NewScanner(data)
+		for s.Next() {
+			subjects = append(subjects, string(s.View().Get("Subject")))
+		}
+		if strings.Join(subjects, ",") != "Three,Quoting" {
+			t.Error("Unexpected messages after concurrent edits: ", subjects)
+		}
+	})
+}
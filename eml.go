@@ -0,0 +1,165 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteEML writes the message as a standalone RFC 5322 message, suitable for
+// saving as an .eml file.  The From marker line is omitted, body lines are
+// unescaped according to the given dialect, and the blank line separating the
+// message from its successor in the mailbox is dropped.  All other bytes are
+// written as they appeared in the input.
+//
+// Like WriteTo(), WriteEML reads the body, and so must be called before any of
+// the body has been read.
+func (m *Message) WriteEML(w io.Writer, d Dialect) error {
+	return writeEML(w, m.headerBlock(), m.BodyReader(), d)
+}
+
+// WriteEML writes the message as a standalone RFC 5322 message, as
+// Message.WriteEML does.
+func (v *View) WriteEML(w io.Writer, d Dialect) error {
+	hdr := v.Raw[lineEnd(v.Raw, 0) : len(v.Raw)-len(v.Body)]
+	return writeEML(w, hdr, bytes.NewReader(v.Body), d)
+}
+
+// WireEML answers the message as WriteEML writes it, but with every line
+// ending in CRLF, as network protocols such as IMAP and POP3 transmit
+// messages.
+func (v *View) WireEML(d Dialect) []byte {
+	var b bytes.Buffer
+	v.WriteEML(&b, d)
+	return toCRLF(b.Bytes())
+}
+
+// toCRLF converts bare LF line endings to CRLF.
+func toCRLF(b []byte) []byte {
+	n := bytes.Count(b, []byte("\n")) - bytes.Count(b, []byte("\r\n"))
+	if n == 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b)+n)
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// writeEML implements WriteEML for a raw header block, including the blank
+// line ending it, and a body.
+func writeEML(w io.Writer, header []byte, body io.Reader, d Dialect) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(header)
+
+	// Hold each line back until we know it isn't the final separator.
+	br := bufio.NewReader(body)
+	var pending []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if pending != nil {
+				bw.Write(d.unescapeLine(pending))
+			}
+			pending = line
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if pending != nil && !isBlankLine(pending) {
+		bw.Write(d.unescapeLine(pending))
+	}
+	return bw.Flush()
+}
+
+// EMLNaming selects how ExportEML names the files it creates.
+type EMLNaming int
+
+const (
+	// NameByIndex names each file after the message's position in the
+	// mailbox, counting from one: 000001.eml, 000002.eml, and so on.
+	NameByIndex EMLNaming = iota
+
+	// NameByMessageID names each file after the message's Message-ID
+	// header, with characters unsafe in file names replaced.  Messages
+	// lacking a Message-ID, or repeating one already used, fall back to
+	// NameByIndex.
+	NameByMessageID
+)
+
+// ExportEML writes each remaining message of the stream into its own .eml
+// file within dir, which must already exist.  Existing files are never
+// overwritten: should a name be taken, whether by an earlier message or by a
+// file already in dir, a numeric suffix is added, as in 000001-2.eml.  It
+// answers the number of files written.
+func ExportEML(s *MboxStream, dir string, d Dialect, naming EMLNaming) (n int, err error) {
+	used := make(map[string]bool)
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		name := fmt.Sprintf("%06d", n+1)
+		if naming == NameByMessageID {
+			id := safeFileName(msg.MessageID())
+			if id != "" && !used[id] {
+				name = id
+				used[id] = true
+			}
+		}
+
+		f, err := createEML(dir, name)
+		if err != nil {
+			return n, err
+		}
+		err = msg.WriteEML(f, d)
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// createEML creates a new .eml file in dir with the given name, adding a
+// numeric suffix if a file by that name already exists.
+func createEML(dir, name string) (*os.File, error) {
+	path := filepath.Join(dir, name+".eml")
+	for n := 2; ; n++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d.eml", name, n))
+	}
+}
+
+// safeFileName replaces characters which are troublesome in file names.
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r == 127 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,101 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Given an mboxrd message with escaped From lines
+// When I write it as an .eml file
+// Then I expect the envelope gone, the escaping reversed, and the separator dropped.
+func TestWriteEML10(t *testing.T) {
+	source := "From foo@bar.com\nSubject: Quoting\n\n>From the top\n>>From the middle\n\n"
+	withOpenMboxStream(t, "TestWriteEML10", source, func(mr *MboxStream) {
+		msg, err := mr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := msg.WriteEML(&b, Mboxrd); err != nil {
+			t.Fatal("TestWriteEML10: ", err)
+		}
+		expected := "Subject: Quoting\n\nFrom the top\n>From the middle\n"
+		if b.String() != expected {
+			t.Errorf("Expected %q; got %q", expected, b.String())
+		}
+	})
+}
+
+// Given an mboxrd message mixing LF and CRLF line endings
+// When I render it for the wire
+// Then I expect it unescaped, with every line ending in CRLF.
+func TestWireEML10(t *testing.T) {
+	s := NewScanner([]byte("From foo@bar.com\nSubject: Quoting\r\n\n>From the top\r\nplain\n\n"))
+	if !s.Next() {
+		t.Fatal("TestWireEML10: ", s.Err())
+	}
+	expected := "Subject: Quoting\r\n\r\nFrom the top\r\nplain\r\n"
+	if got := string(s.View().WireEML(Mboxrd)); got != expected {
+		t.Errorf("Expected %q; got %q", expected, got)
+	}
+}
+
+// Given a mailbox with three messages, two of which share a Message-ID
+// When I export it by Message-ID
+// Then I expect the duplicate to fall back to its index.
+func TestExportEML10(t *testing.T) {
+	source := strings.Replace(mboxWithMessageIDs, "<three@bar.com>", "<one@bar.com>", 1)
+	dir := t.TempDir()
+	withOpenMboxStream(t, "TestExportEML10", source, func(mr *MboxStream) {
+		n, err := ExportEML(mr, dir, Mboxo, NameByMessageID)
+		if err != nil {
+			t.Fatal("TestExportEML10: ", err)
+		}
+		if n != 3 {
+			t.Error("Expected 3 files; got ", n)
+		}
+		for _, name := range []string{"one@bar.com.eml", "two@bar.com.eml", "000003.eml"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Error("Expected file: ", err)
+			}
+		}
+		data, _ := os.ReadFile(filepath.Join(dir, "two@bar.com.eml"))
+		if !strings.HasSuffix(string(data), "Second.\nFrom the archives.\n") {
+			t.Errorf("Body not unescaped: %q", data)
+		}
+	})
+}
+
+// Given a mailbox whose Message-IDs match the names of index-named files
+// When I export it by Message-ID into a directory already holding one of them
+// Then I expect every message in a file of its own, and nothing overwritten.
+func TestExportEML20(t *testing.T) {
+	source := strings.Replace(mboxWithMessageIDs, "<one@bar.com>", "<000002>", 1)
+	source = strings.Replace(source, "<two@bar.com>", "", 1)
+	source = strings.Replace(source, "<three@bar.com>", "", 1)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "000003.eml"), []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	withOpenMboxStream(t, "TestExportEML20", source, func(mr *MboxStream) {
+		n, err := ExportEML(mr, dir, Mboxo, NameByMessageID)
+		if err != nil {
+			t.Fatal("TestExportEML20: ", err)
+		}
+		if n != 3 {
+			t.Error("Expected 3 files; got ", n)
+		}
+	})
+	for _, name := range []string{"000002.eml", "000002-2.eml", "000003-2.eml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error("Expected file: ", err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "000003.eml")); string(data) != "keep" {
+		t.Error("Existing file overwritten: ", string(data))
+	}
+}
@@ -0,0 +1,90 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// An Envelope holds the information recorded on a message's From marker line:
+// the envelope sender and the time the message was delivered.
+type Envelope struct {
+	Sender string
+	Date   time.Time
+}
+
+// envelopeDateLayouts lists the date formats found on From lines in the wild.
+// The first is the canonical asctime() format.
+var envelopeDateLayouts = []string{
+	time.ANSIC,
+	"Mon Jan _2 15:04:05 MST 2006",
+	"Mon Jan _2 15:04:05 -0700 2006",
+	"Mon Jan _2 15:04 2006",
+	"Mon, _2 Jan 2006 15:04:05 -0700",
+}
+
+// String renders the envelope as a complete From marker line, without a line
+// terminator.  An empty sender is rendered as MAILER-DAEMON, and a zero date
+// as the current time.
+func (e Envelope) String() string {
+	sender := strings.TrimSpace(e.Sender)
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	date := e.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return "From " + sender + " " + date.Format(time.ANSIC)
+}
+
+// ParseEnvelope decodes a From marker line.  The leading "From " may be
+// omitted.  If the sender can be found but the date cannot be understood, the
+// sender is still returned along with an error.
+func ParseEnvelope(line string) (e Envelope, err error) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "From "))
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return e, fmt.Errorf("Sender address expected")
+	}
+	e.Sender = fields[0]
+
+	rest := strings.Join(fields[1:], " ")
+	for _, layout := range envelopeDateLayouts {
+		if e.Date, err = time.Parse(layout, rest); err == nil {
+			return e, nil
+		}
+	}
+	return e, fmt.Errorf("Unrecognized envelope date %q", rest)
+}
+
+// Envelope decodes the message's From marker line.
+func (m *Message) Envelope() (Envelope, error) {
+	return ParseEnvelope(m.sendingAddress)
+}
+
+// EnvelopeOf synthesizes an envelope for an RFC 5322 message lacking one.  The
+// sender comes from the Return-Path header, or failing that, the From header.
+// The date comes from the most recent Received header, or failing that, the
+// Date header.  Either may be left empty if the headers give no clue, in which
+// case Envelope.String() supplies defaults.
+func EnvelopeOf(h mail.Header) (e Envelope) {
+	if rp := strings.TrimSpace(h.Get("Return-Path")); rp != "" {
+		e.Sender = strings.TrimSuffix(strings.TrimPrefix(rp, "<"), ">")
+	} else if addr, err := mail.ParseAddress(h.Get("From")); err == nil {
+		e.Sender = addr.Address
+	}
+
+	if received := h["Received"]; len(received) > 0 {
+		if k := strings.LastIndex(received[0], ";"); k >= 0 {
+			e.Date, _ = mail.ParseDate(strings.TrimSpace(received[0][k+1:]))
+		}
+	}
+	if e.Date.IsZero() {
+		e.Date, _ = h.Date()
+	}
+	return
+}
@@ -0,0 +1,126 @@
+// vim: ts=8 noexpandtab ai
+
+// Package gmail understands the mailboxes produced by Google Takeout, which
+// record each message's Gmail labels in an X-Gmail-Labels header and its
+// conversation in an X-GM-THRID header.
+package gmail
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// Labels answers the Gmail labels recorded in the header, in order.  Labels
+// are separated by commas; those containing commas or quotes are quoted, with
+// backslash escapes.  Nested labels keep their slashes, as in "Work/Projects".
+func Labels(h mail.Header) []string {
+	return ParseLabels(h.Get("X-Gmail-Labels"))
+}
+
+// ParseLabels splits the value of an X-Gmail-Labels header into labels.
+// Empty labels are dropped.
+func ParseLabels(v string) []string {
+	var labels []string
+	var label strings.Builder
+	quoted, escaped := false, false
+	flush := func() {
+		if s := strings.TrimSpace(label.String()); s != "" {
+			labels = append(labels, s)
+		}
+		label.Reset()
+	}
+	for _, c := range v {
+		switch {
+		case escaped:
+			label.WriteRune(c)
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			flush()
+		default:
+			label.WriteRune(c)
+		}
+	}
+	flush()
+	return labels
+}
+
+// HasLabel answers true if the header carries the given label, compared
+// without regard to case, as Gmail does.
+func HasLabel(h mail.Header, label string) bool {
+	for _, l := range Labels(h) {
+		if strings.EqualFold(l, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// ThreadID answers the Gmail thread identifier recorded in the header.  The
+// second result is false if the header is missing or malformed.
+func ThreadID(h mail.Header) (uint64, bool) {
+	id, err := strconv.ParseUint(strings.TrimSpace(h.Get("X-GM-THRID")), 10, 64)
+	return id, err == nil
+}
+
+// MaildirFlags translates Gmail's system labels into Maildir flags: a message
+// lacking the Unread label is seen ("S"), and a Starred one is flagged ("F").
+func MaildirFlags(labels []string) string {
+	flags := "S"
+	for _, l := range labels {
+		switch {
+		case strings.EqualFold(l, "Unread"):
+			flags = strings.Replace(flags, "S", "", 1)
+		case strings.EqualFold(l, "Starred"):
+			flags = "F" + flags
+		}
+	}
+	return flags
+}
+
+// ForEachLabel reads every remaining message of the stream and calls fn once
+// for each of its labels, in order, with a view of the whole message.
+// Messages without labels are passed once, with an empty label.  The view is
+// only valid until fn returns.  Iteration stops at the first error fn
+// returns.
+func ForEachLabel(s *mbox.MboxStream, fn func(label string, v *mbox.View) error) error {
+	var raw bytes.Buffer
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		raw.Reset()
+		if _, err = msg.WriteTo(&raw); err != nil {
+			return err
+		}
+
+		sc := mbox.NewScanner(raw.Bytes())
+		if !sc.Next() {
+			return sc.Err()
+		}
+		v := sc.View()
+		v.Offset = msg.Offset()
+
+		labels := Labels(msg.MailHeader())
+		if len(labels) == 0 {
+			labels = []string{""}
+		}
+		for _, label := range labels {
+			if err = fn(label, v); err != nil {
+				return err
+			}
+		}
+	}
+}
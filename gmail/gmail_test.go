@@ -0,0 +1,81 @@
+// vim: ts=8 noexpandtab ai
+
+package gmail
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/sam-falvo/mbox"
+)
+
+const takeout = `From 1234567890123456789@xxx Mon Jan  2 15:04:05 +0000 2006
+X-GM-THRID: 1234567890123456789
+X-Gmail-Labels: Inbox,"Category Updates",Work/Projects,Unread,"Odd, \"quoted\" label"
+From: Foo S. Ball <foo@bar.com>
+Subject: First
+
+Body one.
+
+From 1234567890123456790@xxx Mon Jan  2 15:04:06 +0000 2006
+X-GM-THRID: 1234567890123456789
+Subject: Second
+
+Body two.
+`
+
+// Given X-Gmail-Labels values with quoted and escaped labels
+// When I parse them
+// Then I expect each label, unquoted, in order.
+func TestParseLabels10(t *testing.T) {
+	labels := ParseLabels(`Inbox,"Category Updates",Work/Projects,Unread,"Odd, \"quoted\" label"`)
+	expected := `Inbox|Category Updates|Work/Projects|Unread|Odd, "quoted" label`
+	if got := strings.Join(labels, "|"); got != expected {
+		t.Errorf("Expected %q; got %q", expected, got)
+	}
+	if len(ParseLabels(" , ")) != 0 {
+		t.Error("Empty labels should be dropped")
+	}
+}
+
+// Given a header from a Takeout mailbox
+// When I ask for its thread ID and flags
+// Then I expect them decoded.
+func TestThreadID10(t *testing.T) {
+	h := mail.Header{"X-Gm-Thrid": {"1234567890123456789"}, "X-Gmail-Labels": {"Starred,Inbox"}}
+	if id, ok := ThreadID(h); !ok || id != 1234567890123456789 {
+		t.Error("Thread ID wrong: ", id, ok)
+	}
+	if _, ok := ThreadID(mail.Header{}); ok {
+		t.Error("Missing thread ID should not be found")
+	}
+	if !HasLabel(h, "inbox") || HasLabel(h, "Unread") {
+		t.Error("HasLabel wrong")
+	}
+	if flags := MaildirFlags(Labels(h)); flags != "FS" {
+		t.Error("Expected flags FS; got ", flags)
+	}
+}
+
+// Given a Takeout mailbox
+// When I iterate over it by label
+// Then I expect each message once per label, and unlabeled messages once.
+func TestForEachLabel10(t *testing.T) {
+	mr, err := mbox.CreateMboxStream(strings.NewReader(takeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	err = ForEachLabel(mr, func(label string, v *mbox.View) error {
+		seen = append(seen, label+"="+string(v.Get("Subject")))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Inbox=First|Category Updates=First|Work/Projects=First|Unread=First|Odd, "quoted" label=First|=Second`
+	if got := strings.Join(seen, "|"); got != expected {
+		t.Errorf("Expected %q; got %q", expected, got)
+	}
+}
@@ -0,0 +1,333 @@
+// vim: ts=8 noexpandtab ai
+
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A part is one node of a message's MIME structure.
+type part struct {
+	header    []byte // including the blank line which ends it
+	body      []byte
+	fields    textproto.MIMEHeader
+	mediaType string // lower case, such as "text/plain"
+	params    map[string]string
+	children  []*part // for multipart types
+	message   *part   // for message/rfc822
+}
+
+// parsePart divides raw content into header and body, and descends into
+// multipart and message/rfc822 content.  Parts lacking a Content-Type default
+// to defaultType, which is text/plain except within multipart/digest.
+func parsePart(raw []byte, defaultType string) *part {
+	p := &part{header: raw, body: raw[len(raw):]}
+	if bytes.HasPrefix(raw, []byte("\r\n")) {
+		p.header, p.body = raw[:2], raw[2:]
+	} else if k := bytes.Index(raw, []byte("\r\n\r\n")); k >= 0 {
+		p.header, p.body = raw[:k+4], raw[k+4:]
+	}
+	p.fields, _ = textproto.NewReader(bufio.NewReader(bytes.NewReader(p.header))).ReadMIMEHeader()
+
+	var err error
+	p.mediaType, p.params, err = mime.ParseMediaType(p.fields.Get("Content-Type"))
+	if err != nil {
+		p.mediaType, p.params = defaultType, map[string]string{}
+		if defaultType == "text/plain" {
+			p.params["charset"] = "us-ascii"
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(p.mediaType, "multipart/") && p.params["boundary"] != "":
+		childType := "text/plain"
+		if p.mediaType == "multipart/digest" {
+			childType = "message/rfc822"
+		}
+		for _, c := range splitMultipart(p.body, p.params["boundary"]) {
+			p.children = append(p.children, parsePart(c, childType))
+		}
+	case p.mediaType == "message/rfc822":
+		p.message = parsePart(p.body, "text/plain")
+	}
+	return p
+}
+
+// splitMultipart answers the raw content of each part of a multipart body,
+// each including its own MIME header.
+func splitMultipart(body []byte, boundary string) [][]byte {
+	delim := []byte("--" + boundary)
+	var parts [][]byte
+	start := -1
+	for p := 0; p < len(body); {
+		e := bytes.IndexByte(body[p:], '\n')
+		if e < 0 {
+			e = len(body)
+		} else {
+			e += p + 1
+		}
+		line := bytes.TrimRight(body[p:e], " \t\r\n")
+		if bytes.HasPrefix(line, delim) {
+			rest := line[len(delim):]
+			if len(rest) == 0 || bytes.Equal(rest, []byte("--")) {
+				if start >= 0 {
+					// The line ending before the delimiter belongs
+					// to the delimiter.
+					end := p
+					if end >= start+2 && bytes.Equal(body[end-2:end], []byte("\r\n")) {
+						end -= 2
+					}
+					parts = append(parts, body[start:end])
+				}
+				if len(rest) > 0 {
+					return parts
+				}
+				start = e
+			}
+		}
+		p = e
+	}
+	return parts
+}
+
+// section locates the part of a message named by a section specification,
+// such as "1.2" or "1.HEADER.FIELDS (To)".  It answers the bytes to send, or
+// false if the section doesn't exist.
+func section(root *part, spec string) ([]byte, bool) {
+	p := root
+	inMessage := true // p's header is a message header, not just MIME's
+	for spec != "" {
+		word := spec
+		if k := strings.IndexByte(spec, '.'); k >= 0 && !strings.HasPrefix(spec, "HEADER.") {
+			word, spec = spec[:k], spec[k+1:]
+		} else {
+			spec = ""
+		}
+		n, err := strconv.Atoi(word)
+		if err != nil {
+			return textSection(p, strings.ToUpper(word), inMessage)
+		}
+		if p.message != nil && !inMessage {
+			p = p.message
+		}
+		switch {
+		case n < 1:
+			return nil, false
+		case p.children != nil:
+			if n > len(p.children) {
+				return nil, false
+			}
+			p = p.children[n-1]
+		case n != 1:
+			return nil, false
+		}
+		inMessage = false
+	}
+	if inMessage {
+		return append(append([]byte{}, p.header...), p.body...), true
+	}
+	return p.body, true
+}
+
+// textSection answers the HEADER, HEADER.FIELDS, HEADER.FIELDS.NOT, TEXT or
+// MIME section of a part.
+func textSection(p *part, spec string, inMessage bool) ([]byte, bool) {
+	if spec == "MIME" {
+		if inMessage {
+			return nil, false
+		}
+		return p.header, true
+	}
+	if !inMessage {
+		if p.message == nil {
+			return nil, false
+		}
+		p = p.message
+	}
+	switch {
+	case spec == "TEXT":
+		return p.body, true
+	case spec == "HEADER":
+		return p.header, true
+	case strings.HasPrefix(spec, "HEADER.FIELDS"):
+		not := strings.HasPrefix(spec, "HEADER.FIELDS.NOT")
+		names := strings.Fields(strings.Trim(spec[strings.IndexByte(spec+"(", '('):], "() "))
+		return filterHeader(p.header, names, not), true
+	}
+	return nil, false
+}
+
+// filterHeader answers the fields of the header named in the list or, if not
+// is set, those not named, followed by a blank line.
+func filterHeader(header []byte, names []string, not bool) []byte {
+	var out []byte
+	keep := false
+	for p := 0; p < len(header); {
+		e := bytes.IndexByte(header[p:], '\n') + p + 1
+		if e == p {
+			e = len(header)
+		}
+		line := header[p:e]
+		if len(line) > 0 && line[0] != ' ' && line[0] != '\t' {
+			keep = false
+			if k := bytes.IndexByte(line, ':'); k > 0 {
+				named := false
+				for _, n := range names {
+					if strings.EqualFold(strings.TrimSpace(string(line[:k])), n) {
+						named = true
+					}
+				}
+				keep = named != not
+			}
+		}
+		if keep {
+			out = append(out, line...)
+		}
+		p = e
+	}
+	return append(out, "\r\n"...)
+}
+
+// envelope renders the ENVELOPE structure of a message header.
+func envelope(h mail.Header) string {
+	from := addressList(h, "From")
+	sender := addressList(h, "Sender")
+	if sender == "NIL" {
+		sender = from
+	}
+	replyTo := addressList(h, "Reply-To")
+	if replyTo == "NIL" {
+		replyTo = from
+	}
+	return "(" + strings.Join([]string{
+		nstring(h.Get("Date")),
+		nstring(h.Get("Subject")),
+		from,
+		sender,
+		replyTo,
+		addressList(h, "To"),
+		addressList(h, "Cc"),
+		addressList(h, "Bcc"),
+		nstring(h.Get("In-Reply-To")),
+		nstring(h.Get("Message-Id")),
+	}, " ") + ")"
+}
+
+// addressList renders the addresses of a header field, or NIL if it has
+// none.
+func addressList(h mail.Header, key string) string {
+	list, err := h.AddressList(key)
+	if err != nil || len(list) == 0 {
+		return "NIL"
+	}
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, a := range list {
+		mailbox, host := a.Address, ""
+		if k := strings.LastIndexByte(a.Address, '@'); k >= 0 {
+			mailbox, host = a.Address[:k], a.Address[k+1:]
+		}
+		fmt.Fprintf(&b, "(%s NIL %s %s)", nstring(mime.QEncoding.Encode("utf-8", a.Name)), nstring(mailbox), nstring(host))
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// bodyStructure renders the BODY or, with extensions, BODYSTRUCTURE of a
+// part.
+func bodyStructure(p *part, extensions bool) string {
+	var b strings.Builder
+	writeBodyStructure(&b, p, extensions)
+	return b.String()
+}
+
+func writeBodyStructure(b *strings.Builder, p *part, extensions bool) {
+	typ, subtype := p.mediaType, ""
+	if k := strings.IndexByte(typ, '/'); k >= 0 {
+		typ, subtype = typ[:k], typ[k+1:]
+	}
+
+	b.WriteByte('(')
+	if p.children != nil {
+		for _, c := range p.children {
+			writeBodyStructure(b, c, extensions)
+		}
+		if len(p.children) == 0 {
+			// A multipart body must have at least one part.
+			b.WriteString(`("TEXT" "PLAIN" NIL NIL NIL "7BIT" 0 0)`)
+		}
+		b.WriteString(" " + quote(strings.ToUpper(subtype)))
+		if extensions {
+			b.WriteString(" " + paramList(p.params))
+		}
+		b.WriteByte(')')
+		return
+	}
+
+	encoding := p.fields.Get("Content-Transfer-Encoding")
+	if encoding == "" {
+		encoding = "7BIT"
+	}
+	fmt.Fprintf(b, "%s %s %s %s %s %s %d",
+		quote(strings.ToUpper(typ)), quote(strings.ToUpper(subtype)), paramList(p.params),
+		nstring(p.fields.Get("Content-Id")), nstring(p.fields.Get("Content-Description")),
+		quote(strings.ToUpper(encoding)), len(p.body))
+	switch {
+	case p.message != nil:
+		h, _ := mail.ReadMessage(bytes.NewReader(p.body))
+		var mh mail.Header
+		if h != nil {
+			mh = h.Header
+		}
+		b.WriteString(" " + envelope(mh) + " ")
+		writeBodyStructure(b, p.message, extensions)
+		fmt.Fprintf(b, " %d", bytes.Count(p.body, []byte("\n")))
+	case typ == "text":
+		fmt.Fprintf(b, " %d", bytes.Count(p.body, []byte("\n")))
+	}
+	if extensions {
+		b.WriteString(" NIL " + disposition(p.fields.Get("Content-Disposition")))
+	}
+	b.WriteByte(')')
+}
+
+// paramList renders MIME parameters as a parenthesized list, or NIL if there
+// are none.
+func paramList(params map[string]string) string {
+	if len(params) == 0 {
+		return "NIL"
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var items []string
+	for _, k := range keys {
+		items = append(items, quote(strings.ToUpper(k)), quote(params[k]))
+	}
+	return "(" + strings.Join(items, " ") + ")"
+}
+
+// disposition renders a Content-Disposition header, or NIL if it's absent.
+func disposition(v string) string {
+	d, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return "NIL"
+	}
+	return "(" + quote(strings.ToUpper(d)) + " " + paramList(params) + ")"
+}
+
+// imapDate renders a time as IMAP's date-time, as used by INTERNALDATE.
+func imapDate(t time.Time) string {
+	return `"` + t.Format("02-Jan-2006 15:04:05 -0700") + `"`
+}
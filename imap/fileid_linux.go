@@ -0,0 +1,19 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build linux
+
+package imap
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file independently of its name: replacing a file by
+// renaming another over it changes its ID, while appending to it doesn't.
+func fileID(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return uint64(fi.ModTime().UnixNano())
+}
@@ -0,0 +1,247 @@
+// vim: ts=8 noexpandtab ai
+
+package imap
+
+import (
+	"bytes"
+	"hash/crc32"
+	"math"
+	"net/mail"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A mailbox holds a snapshot of a folder, taken when a client selects it.
+// Messages delivered afterwards become visible when the client selects the
+// folder again.
+type mailbox struct {
+	name        string
+	mm          *mbox.MappedMbox
+	dialect     mbox.Dialect
+	uidValidity uint32
+	uidNext     uint32
+	uids        []uint32
+	sizes       []int
+}
+
+// openMailbox maps a folder into memory and assigns its messages UIDs.
+//
+// A message's UID is its byte offset within the file, plus one.  Appending to
+// an mbox file leaves existing offsets alone, so UIDs survive delivery, and
+// new messages always receive larger UIDs than old ones.  Files of 4 GiB or
+// more can't number their messages this way, so they fall back on sequence
+// numbers, which likewise survive appending; a mailbox which grows past that
+// size renumbers every message, so it gets a new UIDVALIDITY.
+//
+// Any other change moves messages to new offsets, so clients must discard
+// what they cached.  UIDVALIDITY derives from the file's identity, size and
+// modification time, so it changes whenever the file does, whether it's
+// rewritten in place or replaced as mbox.Editor does.  The cache then spares
+// clients that upheaval if the mailbox has merely grown since it last saw it.
+func openMailbox(name string, f *mbox.Folder, cache *validityCache) (*mailbox, error) {
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	mm, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	d, err := mbox.DetectDialectAt(bytes.NewReader(mm.Bytes()))
+	if err != nil {
+		mm.Close()
+		return nil, err
+	}
+
+	mb := &mailbox{name: name, mm: mm, dialect: d}
+	size := int64(len(mm.Bytes()))
+	byOffset := size < math.MaxUint32
+	id := fileID(fi)
+	stamp := id ^ uint64(fi.ModTime().UnixNano()) ^ uint64(size)
+	base := crc32.ChecksumIEEE([]byte(f.Path)) ^ uint32(stamp) ^ uint32(stamp>>32)
+	marks := make([]mark, mm.Len())
+	for i := range marks {
+		v := mm.Message(i)
+		h := crc32.NewIEEE()
+		h.Write(v.Envelope)
+		h.Write(v.Header)
+		marks[i] = mark{offset: v.Offset, sum: h.Sum32()}
+	}
+	mb.uidValidity = cache.validity(f.Path, id, byOffset, base, marks)
+
+	mb.uids = make([]uint32, mm.Len())
+	mb.sizes = make([]int, mm.Len())
+	for i := range mb.uids {
+		if byOffset {
+			mb.uids[i] = uint32(mm.Message(i).Offset) + 1
+		} else {
+			mb.uids[i] = uint32(i + 1)
+		}
+		mb.sizes[i] = -1
+	}
+	mb.uidNext = uint32(len(mb.uids)) + 1
+	if byOffset {
+		mb.uidNext = uint32(size) + 1
+	}
+	return mb, nil
+}
+
+// A validityCache remembers the mailboxes clients have opened, so that one
+// which has only had messages appended keeps its UIDVALIDITY.  The zero value
+// is ready to use.
+type validityCache struct {
+	mu    sync.Mutex
+	known map[string]validityState
+}
+
+// A validityState records a mailbox as last opened: whether its UIDs were
+// offsets or sequence numbers, and a mark for each message.
+type validityState struct {
+	id       uint64
+	byOffset bool
+	validity uint32
+	marks    []mark
+}
+
+// A mark identifies a message by its offset and a checksum of its envelope
+// and header.
+type mark struct {
+	offset int64
+	sum    uint32
+}
+
+// validity answers the UIDVALIDITY for a mailbox whose messages bear the given
+// marks.  If the same file held the same messages, at the same offsets, when
+// it was last opened, and its UIDs are still assigned the same way, their
+// UIDVALIDITY is kept; otherwise base is used.
+func (c *validityCache) validity(path string, id uint64, byOffset bool, base uint32, marks []mark) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.known[path]
+	v := base
+	if ok && old.id == id && old.byOffset == byOffset && len(old.marks) <= len(marks) && slices.Equal(old.marks, marks[:len(old.marks)]) {
+		v = old.validity
+	} else if ok && v == old.validity {
+		v++
+	}
+	if v == 0 {
+		v = 1
+	}
+	if c.known == nil {
+		c.known = make(map[string]validityState)
+	}
+	c.known[path] = validityState{id: id, byOffset: byOffset, validity: v, marks: marks}
+	return v
+}
+
+func (mb *mailbox) close() error {
+	return mb.mm.Close()
+}
+
+// len answers the number of messages in the mailbox.
+func (mb *mailbox) len() int {
+	return len(mb.uids)
+}
+
+// largestUID answers the UID of the last message, or zero if there are none.
+func (mb *mailbox) largestUID() uint32 {
+	if len(mb.uids) == 0 {
+		return 0
+	}
+	return mb.uids[len(mb.uids)-1]
+}
+
+// content answers the i'th message, counting from zero, as a client sees it:
+// without its From marker line, unescaped, and with CRLF line endings.
+func (mb *mailbox) content(i int) []byte {
+	content := mb.mm.Message(i).WireEML(mb.dialect)
+	mb.sizes[i] = len(content)
+	return content
+}
+
+// size answers the length of content(i), computing it only once.
+func (mb *mailbox) size(i int) int {
+	if mb.sizes[i] < 0 {
+		mb.content(i)
+	}
+	return mb.sizes[i]
+}
+
+// header answers the i'th message's header.
+func (mb *mailbox) header(i int) mail.Header {
+	return mb.mm.Message(i).MailHeader()
+}
+
+// flags derives IMAP flags from the Status and X-Status headers, as written
+// by mutt, pine and others.
+func (mb *mailbox) flags(i int) []string {
+	v := mb.mm.Message(i)
+	var flags []string
+	status, xstatus := string(v.Get("Status")), string(v.Get("X-Status"))
+	if strings.ContainsRune(xstatus, 'A') {
+		flags = append(flags, `\Answered`)
+	}
+	if strings.ContainsRune(xstatus, 'F') {
+		flags = append(flags, `\Flagged`)
+	}
+	if strings.ContainsRune(xstatus, 'D') {
+		flags = append(flags, `\Deleted`)
+	}
+	if strings.ContainsRune(xstatus, 'T') {
+		flags = append(flags, `\Draft`)
+	}
+	if strings.ContainsRune(status, 'R') {
+		flags = append(flags, `\Seen`)
+	}
+	return flags
+}
+
+// hasFlag answers true if the i'th message carries the flag.
+func (mb *mailbox) hasFlag(i int, flag string) bool {
+	for _, f := range mb.flags(i) {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalDate answers when the i'th message was delivered, according to its
+// From marker line, or failing that its Date header.
+func (mb *mailbox) internalDate(i int) time.Time {
+	v := mb.mm.Message(i)
+	if env, err := mbox.ParseEnvelope(string(v.Envelope)); err == nil {
+		return env.Date
+	}
+	if d, err := v.MailHeader().Date(); err == nil {
+		return d
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// firstUnseen answers the sequence number of the first message lacking the
+// \Seen flag, or zero if every message has it.
+func (mb *mailbox) firstUnseen() int {
+	for i := range mb.uids {
+		if !mb.hasFlag(i, `\Seen`) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// countUnseen answers how many messages lack the \Seen flag.
+func (mb *mailbox) countUnseen() int {
+	n := 0
+	for i := range mb.uids {
+		if !mb.hasFlag(i, `\Seen`) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,348 @@
+// vim: ts=8 noexpandtab ai
+
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits on what a client may send, protecting the server from runaway
+// input.  The command limit counts every byte of a command, literals
+// included.  No command this server accepts needs more.
+const (
+	maxCommandLength = 2 << 20
+	maxLiteralLength = 1 << 20
+)
+
+// An atom is an unquoted word of a command, such as a command name, a flag,
+// or a fetch item like BODY.PEEK[HEADER].  Quoted strings and literals are
+// represented as plain strings, and parenthesized lists as []interface{}.
+type atom string
+
+// A parser reads commands from a client.
+type parser struct {
+	r *bufio.Reader
+
+	// cont is called when the client sends a literal, to invite it to
+	// send the literal's contents.
+	cont func() error
+
+	read  int    // bytes read of the current command, literals included
+	eol   bool   // true once the command's final line ending is read
+	tag   string // the current command's tag, once read
+	ioErr error  // the first error reading from the client
+}
+
+// A syntaxError reports a malformed command, which the client may follow with
+// others.  Any other error from readCommand ends the connection.
+type syntaxError string
+
+func (e syntaxError) Error() string {
+	return string(e)
+}
+
+// readCommand reads a complete command, including any literals, and splits it
+// into its arguments.
+func (p *parser) readCommand() ([]interface{}, error) {
+	p.read, p.eol, p.tag = 0, false, ""
+	args, err := p.items(false)
+	if err == nil {
+		return args, nil
+	}
+	if p.ioErr != nil {
+		return nil, p.ioErr
+	}
+	// Discard the rest of the line, so the next command starts afresh.
+	for !p.eol {
+		if _, e := p.next(); e != nil {
+			return nil, e
+		}
+	}
+	return nil, err
+}
+
+func (p *parser) next() (byte, error) {
+	if p.ioErr != nil {
+		return 0, p.ioErr
+	}
+	c, err := p.r.ReadByte()
+	if err == nil {
+		if p.read++; p.read > maxCommandLength {
+			err = fmt.Errorf("Command too long")
+		}
+	}
+	if err != nil {
+		p.ioErr = err
+		return 0, err
+	}
+	p.eol = c == '\n'
+	return c, nil
+}
+
+func (p *parser) peek() (byte, error) {
+	if p.ioErr != nil {
+		return 0, p.ioErr
+	}
+	b, err := p.r.Peek(1)
+	if err != nil {
+		p.ioErr = err
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// items reads space-separated items up to the end of the line or, inside a
+// list, up to the closing parenthesis.
+func (p *parser) items(inList bool) ([]interface{}, error) {
+	items := []interface{}{}
+	for {
+		c, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch c {
+		case ' ':
+			p.next()
+		case '\r', '\n':
+			p.next()
+			if c == '\r' {
+				if c, err = p.next(); err != nil {
+					return nil, err
+				}
+				if c != '\n' {
+					return nil, syntaxError("Bare CR")
+				}
+			}
+			if inList {
+				return nil, syntaxError("Unterminated list")
+			}
+			return items, nil
+		case ')':
+			p.next()
+			if !inList {
+				return nil, syntaxError("Unexpected )")
+			}
+			return items, nil
+		case '(':
+			p.next()
+			list, err := p.items(true)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, list)
+		case '"':
+			p.next()
+			s, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s)
+		case '{':
+			p.next()
+			s, err := p.literal()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s)
+		default:
+			a, err := p.atom()
+			if err != nil {
+				return nil, err
+			}
+			if !inList && len(items) == 0 {
+				p.tag = string(a)
+			}
+			items = append(items, a)
+		}
+	}
+}
+
+func (p *parser) quoted() (string, error) {
+	var b strings.Builder
+	for {
+		c, err := p.next()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if c, err = p.next(); err != nil {
+				return "", err
+			}
+		case '\r', '\n':
+			return "", syntaxError("Unterminated string")
+		}
+		b.WriteByte(c)
+	}
+}
+
+func (p *parser) literal() (string, error) {
+	var digits strings.Builder
+	for {
+		c, err := p.next()
+		if err != nil {
+			return "", err
+		}
+		if c == '}' {
+			break
+		}
+		if digits.Len() > 10 {
+			return "", syntaxError("Bad literal length")
+		}
+		digits.WriteByte(c)
+	}
+	n, err := strconv.Atoi(digits.String())
+	if err != nil || n < 0 {
+		return "", syntaxError("Bad literal length")
+	}
+	// Refuse oversized literals before inviting the client to send them.
+	if n > maxLiteralLength || p.read+n > maxCommandLength {
+		return "", syntaxError("Literal too long")
+	}
+	c, err := p.next()
+	if c == '\r' {
+		c, err = p.next()
+	}
+	if err != nil {
+		return "", err
+	}
+	if c != '\n' {
+		return "", syntaxError("Literal length must end the line")
+	}
+	if err = p.cont(); err != nil {
+		p.ioErr = err
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(p.r, buf); err != nil {
+		p.ioErr = err
+		return "", err
+	}
+	p.read += n
+	p.eol = false
+	return string(buf), nil
+}
+
+// atom reads an atom.  Square brackets, as in BODY[HEADER.FIELDS (To)], may
+// enclose spaces and parentheses, and an angle-bracketed partial range may
+// follow them.
+func (p *parser) atom() (atom, error) {
+	var b strings.Builder
+	depth := 0
+	for {
+		c, err := p.peek()
+		if err != nil {
+			return "", err
+		}
+		if depth == 0 && (c == ' ' || c == '(' || c == ')' || c == '\r' || c == '\n' || c == '"' || c == '{') {
+			return atom(b.String()), nil
+		}
+		if depth > 0 && (c == '\r' || c == '\n') {
+			return "", syntaxError("Unterminated [")
+		}
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+		p.next()
+		b.WriteByte(c)
+	}
+}
+
+// asString answers an argument as a string, whether it was sent as an atom, a
+// quoted string or a literal.
+func asString(arg interface{}) (string, bool) {
+	switch a := arg.(type) {
+	case atom:
+		return string(a), true
+	case string:
+		return a, true
+	}
+	return "", false
+}
+
+// A seqRange holds an inclusive range of message numbers or UIDs.  Zero stands
+// for "*", the largest number in use.
+type seqRange struct {
+	lo, hi uint32
+}
+
+// parseSeqSet parses a sequence set, such as "1:4,7,9:*".
+func parseSeqSet(s string) ([]seqRange, error) {
+	var set []seqRange
+	for _, part := range strings.Split(s, ",") {
+		lo, hi := part, part
+		if k := strings.IndexByte(part, ':'); k >= 0 {
+			lo, hi = part[:k], part[k+1:]
+		}
+		a, err := parseSeqNumber(lo)
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseSeqNumber(hi)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, seqRange{a, b})
+	}
+	return set, nil
+}
+
+func parseSeqNumber(s string) (uint32, error) {
+	if s == "*" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("Bad sequence number %q", s)
+	}
+	return uint32(n), nil
+}
+
+// contains answers true if the set includes n, given the largest number in
+// use.
+func contains(set []seqRange, n, largest uint32) bool {
+	for _, r := range set {
+		lo, hi := r.lo, r.hi
+		if lo == 0 {
+			lo = largest
+		}
+		if hi == 0 {
+			hi = largest
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= n && n <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// quote renders a string for a response: as a quoted string where possible,
+// or else as a literal.
+func quote(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '\r' || c == '\n' || c >= 0x80 || c == 0 {
+			return fmt.Sprintf("{%d}\r\n%s", len(s), s)
+		}
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// nstring renders a string as quote does, or NIL if it's empty.
+func nstring(s string) string {
+	if s == "" {
+		return "NIL"
+	}
+	return quote(s)
+}
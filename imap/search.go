@@ -0,0 +1,315 @@
+// vim: ts=8 noexpandtab ai
+
+package imap
+
+import (
+	"bytes"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A matcher answers true if the i'th message, counting from zero, satisfies
+// a search.
+type matcher func(i int) bool
+
+// compileSearch turns SEARCH arguments into a matcher for the mailbox.  All
+// the keys given must match.
+func compileSearch(mb *mailbox, args []interface{}) (matcher, error) {
+	if len(args) >= 2 {
+		if a, ok := args[0].(atom); ok && strings.EqualFold(string(a), "CHARSET") {
+			cs, _ := asString(args[1])
+			if !strings.EqualFold(cs, "UTF-8") && !strings.EqualFold(cs, "US-ASCII") {
+				return nil, syntaxError("[BADCHARSET (UTF-8 US-ASCII)] Unsupported charset")
+			}
+			args = args[2:]
+		}
+	}
+	c := &searchCompiler{mb: mb, args: args}
+	var all []matcher
+	for len(c.args) > 0 {
+		m, err := c.key()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, m)
+	}
+	return and(all), nil
+}
+
+func and(all []matcher) matcher {
+	return func(i int) bool {
+		for _, m := range all {
+			if !m(i) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+type searchCompiler struct {
+	mb   *mailbox
+	args []interface{}
+}
+
+// next consumes the next argument as a string.
+func (c *searchCompiler) next() (string, error) {
+	if len(c.args) == 0 {
+		return "", syntaxError("Missing search argument")
+	}
+	s, ok := asString(c.args[0])
+	if !ok {
+		return "", syntaxError("Unexpected list in search")
+	}
+	c.args = c.args[1:]
+	return s, nil
+}
+
+func (c *searchCompiler) date() (time.Time, error) {
+	s, err := c.next()
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse("2-Jan-2006", s)
+	if err != nil {
+		return t, syntaxError("Bad date " + s)
+	}
+	return t, nil
+}
+
+func (c *searchCompiler) number() (int64, error) {
+	s, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, syntaxError("Bad number " + s)
+	}
+	return n, nil
+}
+
+// key compiles a single search key.
+func (c *searchCompiler) key() (matcher, error) {
+	mb := c.mb
+	if list, ok := c.args[0].([]interface{}); ok {
+		c.args = c.args[1:]
+		sub := &searchCompiler{mb: mb, args: list}
+		var all []matcher
+		for len(sub.args) > 0 {
+			m, err := sub.key()
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, m)
+		}
+		return and(all), nil
+	}
+
+	word, _ := c.next()
+	if word != "" && (word[0] == '*' || '0' <= word[0] && word[0] <= '9') {
+		set, err := parseSeqSet(word)
+		if err != nil {
+			return nil, syntaxError(err.Error())
+		}
+		return func(i int) bool { return contains(set, uint32(i+1), uint32(mb.len())) }, nil
+	}
+
+	flag := func(f string, want bool) (matcher, error) {
+		return func(i int) bool { return mb.hasFlag(i, f) == want }, nil
+	}
+	constant := func(v bool) (matcher, error) {
+		return func(int) bool { return v }, nil
+	}
+	header := func(name string) (matcher, error) {
+		s, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		return func(i int) bool {
+			for _, v := range mb.header(i)[textproto.CanonicalMIMEHeaderKey(name)] {
+				if containsFold(mbox.DecodeHeader(v), s) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	onDate := func(sent bool, cmp func(d, t time.Time) bool) (matcher, error) {
+		t, err := c.date()
+		if err != nil {
+			return nil, err
+		}
+		return func(i int) bool {
+			d := mb.internalDate(i)
+			if sent {
+				var err error
+				if d, err = mb.header(i).Date(); err != nil {
+					return false
+				}
+			}
+			y, m, day := d.Date()
+			return cmp(time.Date(y, m, day, 0, 0, 0, 0, time.UTC), t)
+		}, nil
+	}
+	before := func(d, t time.Time) bool { return d.Before(t) }
+	on := func(d, t time.Time) bool { return d.Equal(t) }
+	since := func(d, t time.Time) bool { return !d.Before(t) }
+
+	switch strings.ToUpper(word) {
+	case "ALL", "OLD", "UNKEYWORD":
+		if strings.EqualFold(word, "UNKEYWORD") {
+			c.next()
+		}
+		return constant(true)
+	case "NEW", "RECENT", "KEYWORD":
+		if strings.EqualFold(word, "KEYWORD") {
+			c.next()
+		}
+		return constant(false)
+	case "ANSWERED":
+		return flag(`\Answered`, true)
+	case "UNANSWERED":
+		return flag(`\Answered`, false)
+	case "DELETED":
+		return flag(`\Deleted`, true)
+	case "UNDELETED":
+		return flag(`\Deleted`, false)
+	case "DRAFT":
+		return flag(`\Draft`, true)
+	case "UNDRAFT":
+		return flag(`\Draft`, false)
+	case "FLAGGED":
+		return flag(`\Flagged`, true)
+	case "UNFLAGGED":
+		return flag(`\Flagged`, false)
+	case "SEEN":
+		return flag(`\Seen`, true)
+	case "UNSEEN":
+		return flag(`\Seen`, false)
+	case "FROM", "TO", "CC", "BCC", "SUBJECT":
+		return header(word)
+	case "HEADER":
+		name, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		return header(name)
+	case "BODY", "TEXT":
+		s, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		text := strings.EqualFold(word, "TEXT")
+		return func(i int) bool {
+			h := mb.header(i)
+			if text {
+				for _, vs := range h {
+					for _, v := range vs {
+						if containsFold(mbox.DecodeHeader(v), s) {
+							return true
+						}
+					}
+				}
+			}
+			content := mb.content(i)
+			body := content[len(content):]
+			if k := bytes.Index(content, []byte("\r\n\r\n")); k >= 0 {
+				body = content[k+4:]
+			}
+			parts, _ := mbox.Parts(h, bytes.NewReader(body))
+			return containsFold(mbox.Text(parts), s)
+		}, nil
+	case "BEFORE":
+		return onDate(false, before)
+	case "ON":
+		return onDate(false, on)
+	case "SINCE":
+		return onDate(false, since)
+	case "SENTBEFORE":
+		return onDate(true, before)
+	case "SENTON":
+		return onDate(true, on)
+	case "SENTSINCE":
+		return onDate(true, since)
+	case "LARGER", "SMALLER":
+		n, err := c.number()
+		if err != nil {
+			return nil, err
+		}
+		larger := strings.EqualFold(word, "LARGER")
+		return func(i int) bool {
+			if larger {
+				return int64(mb.size(i)) > n
+			}
+			return int64(mb.size(i)) < n
+		}, nil
+	case "UID":
+		s, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		set, err := parseSeqSet(s)
+		if err != nil {
+			return nil, syntaxError(err.Error())
+		}
+		return func(i int) bool { return contains(set, mb.uids[i], mb.largestUID()) }, nil
+	case "NOT":
+		if len(c.args) == 0 {
+			return nil, syntaxError("NOT needs a search key")
+		}
+		m, err := c.key()
+		if err != nil {
+			return nil, err
+		}
+		return func(i int) bool { return !m(i) }, nil
+	case "OR":
+		var ms [2]matcher
+		for k := range ms {
+			if len(c.args) == 0 {
+				return nil, syntaxError("OR needs two search keys")
+			}
+			var err error
+			if ms[k], err = c.key(); err != nil {
+				return nil, err
+			}
+		}
+		return func(i int) bool { return ms[0](i) || ms[1](i) }, nil
+	}
+	return nil, syntaxError("Unknown search key " + word)
+}
+
+// containsFold answers true if s contains substr, without regard to case.
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func (ss *session) search(tag, cmd string, args []interface{}, uid bool) {
+	mb := ss.selected
+	m, err := compileSearch(mb, args)
+	if err != nil {
+		if e, ok := err.(syntaxError); ok && strings.HasPrefix(string(e), "[BADCHARSET") {
+			ss.reply(tag, "NO", "%v", err)
+		} else {
+			ss.reply(tag, "BAD", "%v", err)
+		}
+		return
+	}
+	ss.w.WriteString("* SEARCH")
+	for i := 0; i < mb.len(); i++ {
+		if !m(i) {
+			continue
+		}
+		if uid {
+			ss.w.WriteString(" " + strconv.FormatUint(uint64(mb.uids[i]), 10))
+		} else {
+			ss.w.WriteString(" " + strconv.Itoa(i+1))
+		}
+	}
+	ss.w.WriteString("\r\n")
+	ss.reply(tag, "OK", "%s completed", cmd)
+}
@@ -0,0 +1,655 @@
+// vim: ts=8 noexpandtab ai
+
+// Package imap serves the folders of an mbox.Store, read-only, over IMAP4rev1
+// as described by RFC 3501.  This lets ordinary mail clients browse and
+// search mbox archives without converting them.
+//
+// Clients may LOGIN, LIST folders, SELECT or EXAMINE them, and FETCH and
+// SEARCH their messages, by sequence number or UID.  Commands which would
+// change a mailbox, such as STORE, COPY, EXPUNGE or APPEND, are refused.
+// Message flags are taken from the Status and X-Status headers which mutt and
+// pine maintain.  Folder names are served as they are, without modified
+// UTF-7 encoding, and "/" separates levels of the hierarchy.
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A Server serves the folders of a Store over IMAP.
+type Server struct {
+	// Store holds the folders to serve.
+	Store *mbox.Store
+
+	// Authenticate decides whether to accept a LOGIN.  If nil, every
+	// login is refused.
+	Authenticate func(user, password string) bool
+
+	// ErrorLog receives errors which can't be reported to a client.  If
+	// nil, they're logged through the log package.
+	ErrorLog *log.Logger
+
+	validity validityCache
+}
+
+const capabilities = "IMAP4rev1 UNSELECT"
+
+// idleTimeout bounds how long a client may remain silent.  RFC 3501 requires
+// at least 30 minutes.
+const idleTimeout = 30 * time.Minute
+
+// Serve accepts connections from the listener, serving each in its own
+// goroutine, until accepting fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn conducts a single IMAP session over the connection, closing it
+// when the client logs out or disconnects.
+func (s *Server) ServeConn(c net.Conn) {
+	// Selected mailboxes are mapped into memory; should another program
+	// truncate one, touching it must fail this session, not the process.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer c.Close()
+	defer func() {
+		// A bug provoked by one client mustn't take down every
+		// other session with it.
+		if e := recover(); e != nil {
+			s.logf("imap: %s: panic: %v\n%s", c.RemoteAddr(), e, debug.Stack())
+		}
+	}()
+	ss := &session{srv: s, c: c, w: bufio.NewWriter(c)}
+	ss.p = &parser{r: bufio.NewReader(c), cont: func() error {
+		ss.w.WriteString("+ Ready for literal\r\n")
+		return ss.w.Flush()
+	}}
+	defer ss.unselect()
+
+	ss.untagged("OK [CAPABILITY %s] mbox IMAP server ready", capabilities)
+	for {
+		if err := ss.w.Flush(); err != nil {
+			return
+		}
+		c.SetReadDeadline(time.Now().Add(idleTimeout))
+		args, err := ss.p.readCommand()
+		if e, ok := err.(syntaxError); ok {
+			if ss.p.tag != "" {
+				ss.reply(ss.p.tag, "BAD", "%s", e)
+			} else {
+				ss.untagged("BAD %s", e)
+			}
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				s.logf("imap: %s: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) < 2 {
+			ss.untagged("BAD Expected a tag and a command")
+			continue
+		}
+		tag, ok := args[0].(atom)
+		cmd, ok2 := args[1].(atom)
+		if !ok || !ok2 {
+			ss.untagged("BAD Expected a tag and a command")
+			continue
+		}
+		if ss.run(string(tag), strings.ToUpper(string(cmd)), args[2:]) {
+			ss.w.Flush()
+			return
+		}
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// A session holds the state of one client connection.
+type session struct {
+	srv      *Server
+	c        net.Conn
+	p        *parser
+	w        *bufio.Writer
+	loggedIn bool
+	selected *mailbox
+}
+
+func (ss *session) untagged(format string, args ...interface{}) {
+	ss.w.WriteString("* ")
+	fmt.Fprintf(ss.w, format, args...)
+	ss.w.WriteString("\r\n")
+}
+
+func (ss *session) reply(tag, status, format string, args ...interface{}) {
+	ss.w.WriteString(tag + " " + status + " ")
+	fmt.Fprintf(ss.w, format, args...)
+	ss.w.WriteString("\r\n")
+}
+
+func (ss *session) unselect() {
+	if ss.selected != nil {
+		ss.selected.close()
+		ss.selected = nil
+	}
+}
+
+// run dispatches a single command, and answers true if the session should
+// end.  If the selected mailbox's file shrinks beneath its mapping, touching
+// the missing pages faults; the session then ends, since whatever response was
+// under way can't be completed.
+func (ss *session) run(tag, cmd string, args []interface{}) (done bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(interface{ Addr() uintptr }); !ok || ss.selected == nil {
+				panic(e)
+			}
+			ss.srv.logf("imap: %s: %s changed while selected: %v", ss.c.RemoteAddr(), ss.selected.name, e)
+			ss.w.WriteString("\r\n")
+			ss.untagged("BYE [ALERT] Mailbox changed by another program")
+			done = true
+		}
+	}()
+	return ss.dispatch(tag, cmd, args)
+}
+
+// dispatch carries out a single command, and answers true if the session
+// should end.
+func (ss *session) dispatch(tag, cmd string, args []interface{}) bool {
+	switch cmd {
+	case "CAPABILITY":
+		ss.untagged("CAPABILITY %s", capabilities)
+		ss.reply(tag, "OK", "CAPABILITY completed")
+		return false
+	case "NOOP", "CHECK":
+		ss.reply(tag, "OK", "%s completed", cmd)
+		return false
+	case "LOGOUT":
+		ss.untagged("BYE Logging out")
+		ss.reply(tag, "OK", "LOGOUT completed")
+		return true
+	case "LOGIN":
+		ss.login(tag, args)
+		return false
+	case "AUTHENTICATE", "STARTTLS":
+		ss.reply(tag, "NO", "%s is not supported; use LOGIN", cmd)
+		return false
+	}
+
+	if !ss.loggedIn {
+		ss.reply(tag, "NO", "Please LOGIN first")
+		return false
+	}
+	switch cmd {
+	case "LIST", "LSUB":
+		ss.list(tag, cmd, args)
+		return false
+	case "SELECT", "EXAMINE":
+		ss.sel(tag, cmd, args)
+		return false
+	case "STATUS":
+		ss.status(tag, args)
+		return false
+	case "CREATE", "DELETE", "RENAME", "SUBSCRIBE", "UNSUBSCRIBE", "APPEND":
+		ss.reply(tag, "NO", "Mailboxes are read-only")
+		return false
+	}
+
+	if ss.selected == nil {
+		ss.reply(tag, "BAD", "No mailbox selected")
+		return false
+	}
+	uid := false
+	if cmd == "UID" {
+		if len(args) == 0 {
+			ss.reply(tag, "BAD", "UID needs a command")
+			return false
+		}
+		a, _ := asString(args[0])
+		cmd, args, uid = "UID "+strings.ToUpper(a), args[1:], true
+	}
+	switch cmd {
+	case "CLOSE", "UNSELECT":
+		ss.unselect()
+		ss.reply(tag, "OK", "%s completed", cmd)
+	case "EXPUNGE", "STORE", "COPY", "UID EXPUNGE", "UID STORE", "UID COPY":
+		ss.reply(tag, "NO", "[READ-ONLY] Mailbox is read-only")
+	case "FETCH", "UID FETCH":
+		ss.fetch(tag, cmd, args, uid)
+	case "SEARCH", "UID SEARCH":
+		ss.search(tag, cmd, args, uid)
+	default:
+		ss.reply(tag, "BAD", "Unknown command %s", cmd)
+	}
+	return false
+}
+
+func (ss *session) login(tag string, args []interface{}) {
+	if ss.loggedIn {
+		ss.reply(tag, "BAD", "Already logged in")
+		return
+	}
+	if len(args) != 2 {
+		ss.reply(tag, "BAD", "LOGIN needs a user name and a password")
+		return
+	}
+	user, ok := asString(args[0])
+	password, ok2 := asString(args[1])
+	if !ok || !ok2 {
+		ss.reply(tag, "BAD", "LOGIN needs a user name and a password")
+		return
+	}
+	if ss.srv.Authenticate == nil || !ss.srv.Authenticate(user, password) {
+		// Slow down password guessing.
+		time.Sleep(time.Second)
+		ss.reply(tag, "NO", "[AUTHENTICATIONFAILED] Invalid credentials")
+		return
+	}
+	ss.loggedIn = true
+	ss.reply(tag, "OK", "[CAPABILITY %s] LOGIN completed", capabilities)
+}
+
+// folder finds the folder an IMAP mailbox name refers to.  INBOX is matched
+// without regard to case, as RFC 3501 requires.
+func (ss *session) folder(name string) (*mbox.Folder, error) {
+	if strings.EqualFold(name, "INBOX") {
+		folders, err := ss.srv.Store.Folders()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range folders {
+			if strings.EqualFold(f.Name, "INBOX") {
+				return f, nil
+			}
+		}
+	}
+	return ss.srv.Store.Folder(name)
+}
+
+// imapName answers the name under which a folder is listed.
+func imapName(f *mbox.Folder) string {
+	if strings.EqualFold(f.Name, "INBOX") {
+		return "INBOX"
+	}
+	return f.Name
+}
+
+func (ss *session) list(tag, cmd string, args []interface{}) {
+	if len(args) != 2 {
+		ss.reply(tag, "BAD", "%s needs a reference and a pattern", cmd)
+		return
+	}
+	ref, ok := asString(args[0])
+	pattern, ok2 := asString(args[1])
+	if !ok || !ok2 {
+		ss.reply(tag, "BAD", "%s needs a reference and a pattern", cmd)
+		return
+	}
+	if pattern == "" {
+		ss.untagged(`%s (\Noselect) "/" ""`, cmd)
+		ss.reply(tag, "OK", "%s completed", cmd)
+		return
+	}
+
+	folders, err := ss.srv.Store.Folders()
+	if err != nil {
+		ss.reply(tag, "NO", "%v", err)
+		return
+	}
+	// Directories holding folders are listed too, but can't be selected.
+	attrs := make(map[string]string)
+	for _, f := range folders {
+		name := imapName(f)
+		attrs[name] = "()"
+		for k := strings.LastIndexByte(name, '/'); k > 0; k = strings.LastIndexByte(name, '/') {
+			name = name[:k]
+			if _, ok := attrs[name]; !ok {
+				attrs[name] = `(\Noselect)`
+			}
+		}
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	re := listPattern(ref + pattern)
+	for _, name := range names {
+		if re.MatchString(name) {
+			ss.untagged(`%s %s "/" %s`, cmd, attrs[name], quote(name))
+		}
+	}
+	ss.reply(tag, "OK", "%s completed", cmd)
+}
+
+// listPattern converts a LIST pattern to a regular expression: "*" matches
+// anything, while "%" stops at the hierarchy delimiter.  INBOX matches
+// without regard to case.
+func listPattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	if len(pattern) >= 5 && strings.EqualFold(pattern[:5], "INBOX") {
+		b.WriteString("INBOX")
+		pattern = pattern[5:]
+	}
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '%':
+			b.WriteString("[^/]*")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (ss *session) open(name string) (*mailbox, error) {
+	f, err := ss.folder(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("[NONEXISTENT] No such mailbox")
+		}
+		return nil, err
+	}
+	return openMailbox(imapName(f), f, &ss.srv.validity)
+}
+
+func (ss *session) sel(tag, cmd string, args []interface{}) {
+	ss.unselect()
+	name, ok := "", len(args) == 1
+	if ok {
+		name, ok = asString(args[0])
+	}
+	if !ok {
+		ss.reply(tag, "BAD", "%s needs a mailbox name", cmd)
+		return
+	}
+	mb, err := ss.open(name)
+	if err != nil {
+		ss.reply(tag, "NO", "%v", err)
+		return
+	}
+	ss.selected = mb
+
+	ss.untagged(`FLAGS (\Answered \Flagged \Deleted \Seen \Draft)`)
+	ss.untagged(`OK [PERMANENTFLAGS ()] No permanent flags permitted`)
+	ss.untagged("%d EXISTS", mb.len())
+	ss.untagged("0 RECENT")
+	if n := mb.firstUnseen(); n > 0 {
+		ss.untagged("OK [UNSEEN %d] First unseen message", n)
+	}
+	ss.untagged("OK [UIDVALIDITY %d] UIDs valid", mb.uidValidity)
+	ss.untagged("OK [UIDNEXT %d] Predicted next UID", mb.uidNext)
+	ss.reply(tag, "OK", "[READ-ONLY] %s completed", cmd)
+}
+
+func (ss *session) status(tag string, args []interface{}) {
+	var name string
+	var items []interface{}
+	ok := len(args) == 2
+	if ok {
+		name, ok = asString(args[0])
+		items, _ = args[1].([]interface{})
+	}
+	if !ok || len(items) == 0 {
+		ss.reply(tag, "BAD", "STATUS needs a mailbox name and a list of items")
+		return
+	}
+	mb, err := ss.open(name)
+	if err != nil {
+		ss.reply(tag, "NO", "%v", err)
+		return
+	}
+	defer mb.close()
+
+	var out []string
+	for _, item := range items {
+		a, _ := asString(item)
+		a = strings.ToUpper(a)
+		switch a {
+		case "MESSAGES":
+			out = append(out, a, strconv.Itoa(mb.len()))
+		case "RECENT":
+			out = append(out, a, "0")
+		case "UIDNEXT":
+			out = append(out, a, strconv.FormatUint(uint64(mb.uidNext), 10))
+		case "UIDVALIDITY":
+			out = append(out, a, strconv.FormatUint(uint64(mb.uidValidity), 10))
+		case "UNSEEN":
+			out = append(out, a, strconv.Itoa(mb.countUnseen()))
+		default:
+			ss.reply(tag, "BAD", "Unknown status item %s", a)
+			return
+		}
+	}
+	ss.untagged("STATUS %s (%s)", quote(mb.name), strings.Join(out, " "))
+	ss.reply(tag, "OK", "STATUS completed")
+}
+
+// A fetchItem is a single data item requested by FETCH.
+type fetchItem struct {
+	name    string // such as FLAGS, or BODY[] for all BODY[...] forms
+	section string // for BODY[...], the section specification
+	peek    bool
+	partial bool
+	offset  int
+	count   int
+}
+
+// parseFetchItems parses FETCH's data item argument.
+func parseFetchItems(arg interface{}) ([]fetchItem, error) {
+	var words []string
+	switch a := arg.(type) {
+	case atom:
+		switch strings.ToUpper(string(a)) {
+		case "ALL":
+			words = []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"}
+		case "FAST":
+			words = []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE"}
+		case "FULL":
+			words = []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"}
+		default:
+			words = []string{string(a)}
+		}
+	case []interface{}:
+		for _, item := range a {
+			w, ok := item.(atom)
+			if !ok {
+				return nil, syntaxError("Bad fetch item")
+			}
+			words = append(words, string(w))
+		}
+	default:
+		return nil, syntaxError("Bad fetch item")
+	}
+
+	var items []fetchItem
+	for _, w := range words {
+		upper := strings.ToUpper(w)
+		switch upper {
+		case "FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "UID", "BODY", "BODYSTRUCTURE", "RFC822", "RFC822.HEADER", "RFC822.TEXT":
+			items = append(items, fetchItem{name: upper})
+			continue
+		}
+		item := fetchItem{name: "BODY[]"}
+		switch {
+		case strings.HasPrefix(upper, "BODY["):
+			upper = upper[len("BODY["):]
+		case strings.HasPrefix(upper, "BODY.PEEK["):
+			upper, item.peek = upper[len("BODY.PEEK["):], true
+		default:
+			return nil, syntaxError("Unknown fetch item " + w)
+		}
+		k := strings.IndexByte(upper, ']')
+		if k < 0 {
+			return nil, syntaxError("Bad fetch item " + w)
+		}
+		item.section, upper = upper[:k], upper[k+1:]
+		if upper != "" {
+			if !strings.HasPrefix(upper, "<") || !strings.HasSuffix(upper, ">") {
+				return nil, syntaxError("Bad fetch item " + w)
+			}
+			// Both numbers are 32-bit unsigned, as RFC 3501
+			// defines number.
+			o, n, _ := strings.Cut(upper[1:len(upper)-1], ".")
+			offset, err := strconv.ParseUint(o, 10, 32)
+			if err != nil {
+				return nil, syntaxError("Bad partial range " + w)
+			}
+			count, err := strconv.ParseUint(n, 10, 32)
+			if err != nil || count == 0 {
+				return nil, syntaxError("Bad partial range " + w)
+			}
+			item.offset, item.count, item.partial = int(offset), int(count), true
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (ss *session) fetch(tag, cmd string, args []interface{}, uid bool) {
+	if len(args) != 2 {
+		ss.reply(tag, "BAD", "%s needs a sequence set and data items", cmd)
+		return
+	}
+	setText, _ := asString(args[0])
+	set, err := parseSeqSet(setText)
+	if err != nil {
+		ss.reply(tag, "BAD", "%v", err)
+		return
+	}
+	items, err := parseFetchItems(args[1])
+	if err != nil {
+		ss.reply(tag, "BAD", "%v", err)
+		return
+	}
+	if uid {
+		items = append([]fetchItem{{name: "UID"}}, items...)
+	}
+
+	mb := ss.selected
+	for i := 0; i < mb.len(); i++ {
+		if uid && !contains(set, mb.uids[i], mb.largestUID()) || !uid && !contains(set, uint32(i+1), uint32(mb.len())) {
+			continue
+		}
+		ss.w.WriteString("* " + strconv.Itoa(i+1) + " FETCH (")
+		ss.fetchOne(i, items)
+		ss.w.WriteString(")\r\n")
+	}
+	ss.reply(tag, "OK", "%s completed", cmd)
+}
+
+// fetchOne writes the requested items of the i'th message.
+func (ss *session) fetchOne(i int, items []fetchItem) {
+	mb := ss.selected
+	var content []byte
+	var root *part
+	getRoot := func() *part {
+		if root == nil {
+			content = mb.content(i)
+			root = parsePart(content, "text/plain")
+		}
+		return root
+	}
+
+	uidDone := false
+	first := true
+	for _, item := range items {
+		if item.name == "UID" {
+			if uidDone {
+				continue
+			}
+			uidDone = true
+		}
+		if !first {
+			ss.w.WriteByte(' ')
+		}
+		first = false
+
+		switch item.name {
+		case "FLAGS":
+			ss.w.WriteString("FLAGS (" + strings.Join(mb.flags(i), " ") + ")")
+		case "INTERNALDATE":
+			ss.w.WriteString("INTERNALDATE " + imapDate(mb.internalDate(i)))
+		case "RFC822.SIZE":
+			ss.w.WriteString("RFC822.SIZE " + strconv.Itoa(mb.size(i)))
+		case "UID":
+			ss.w.WriteString("UID " + strconv.FormatUint(uint64(mb.uids[i]), 10))
+		case "ENVELOPE":
+			ss.w.WriteString("ENVELOPE " + envelope(mb.header(i)))
+		case "BODYSTRUCTURE":
+			ss.w.WriteString("BODYSTRUCTURE " + bodyStructure(getRoot(), true))
+		case "RFC822":
+			getRoot()
+			ss.w.WriteString("RFC822 " + literal(content))
+		case "RFC822.HEADER":
+			ss.w.WriteString("RFC822.HEADER " + literal(getRoot().header))
+		case "RFC822.TEXT":
+			ss.w.WriteString("RFC822.TEXT " + literal(getRoot().body))
+		case "BODY":
+			ss.w.WriteString("BODY " + bodyStructure(getRoot(), false))
+		case "BODY[]":
+			ss.fetchBody(getRoot(), content, item)
+		}
+	}
+}
+
+// fetchBody writes the section of the message requested by a BODY[...] or
+// BODY.PEEK[...] item.  Sections which don't exist are sent empty.
+func (ss *session) fetchBody(root *part, content []byte, item fetchItem) {
+	data, ok := content, true
+	if item.section != "" {
+		data, ok = section(root, item.section)
+	}
+	label := "BODY[" + item.section + "]"
+	if item.partial {
+		label += "<" + strconv.Itoa(item.offset) + ">"
+		if item.offset > len(data) {
+			data = nil
+		} else {
+			end := len(data)
+			if item.count < end-item.offset {
+				end = item.offset + item.count
+			}
+			data = data[item.offset:end]
+		}
+	}
+	if !ok {
+		data = nil
+	}
+	ss.w.WriteString(label + " " + literal(data))
+}
+
+// literal renders bytes as an IMAP literal.
+func literal(b []byte) string {
+	return "{" + strconv.Itoa(len(b)) + "}\r\n" + string(b)
+}
@@ -0,0 +1,425 @@
+// vim: ts=8 noexpandtab ai
+
+package imap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sam-falvo/mbox"
+)
+
+const inbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: Alice <alice@example.com>
+To: Bob <bob@example.com>
+Subject: Lunch
+Date: Mon, 2 Jan 2006 15:04:05 +0000
+Message-ID: <1@example.com>
+Status: RO
+X-Status: A
+
+Shall we?
+>From experience, noon is best.
+
+From carol@example.com Tue Jan  3 09:00:00 2006
+From: Carol <carol@example.com>
+To: Bob <bob@example.com>
+Subject: Report
+Date: Tue, 3 Jan 2006 09:00:00 +0000
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="XYZ"
+
+--XYZ
+Content-Type: text/plain; charset=us-ascii
+
+See attached.
+--XYZ
+Content-Type: application/octet-stream
+Content-Disposition: attachment; filename="report.bin"
+Content-Transfer-Encoding: base64
+
+SGVsbG8s
+--XYZ--
+
+From alice@example.com Wed Jan  4 12:00:00 2006
+From: Alice <alice@example.com>
+To: Bob <bob@example.com>
+Subject: Re: Lunch
+Date: Wed, 4 Jan 2006 12:00:00 +0000
+Status: O
+X-Status: F
+
+Tomorrow, then.
+
+`
+
+// A client speaks just enough IMAP to test the server.
+type client struct {
+	t    *testing.T
+	root string
+	c    net.Conn
+	r    *bufio.Reader
+	tags int
+}
+
+// startServer serves a fresh store on the loopback interface, and answers a
+// client connected to it, already past the greeting.
+func startServer(t *testing.T) *client {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "Lists"), 0700)
+	os.WriteFile(filepath.Join(root, "INBOX"), []byte(inbox), 0600)
+	os.WriteFile(filepath.Join(root, "Lists", "golang"), []byte(""), 0600)
+	store, err := mbox.OpenStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback networking: ", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	srv := &Server{Store: store, Authenticate: func(user, password string) bool {
+		return user == "bob" && password == "secret"
+	}}
+	go srv.Serve(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	cl := &client{t: t, root: root, c: c, r: bufio.NewReader(c)}
+	if greeting := cl.line(); !strings.HasPrefix(greeting, "* OK") {
+		t.Fatal("Bad greeting: ", greeting)
+	}
+	return cl
+}
+
+var literalSuffix = regexp.MustCompile(`\{(\d+)\}$`)
+
+// line reads one response line, with any literals it carries inlined.
+func (cl *client) line() string {
+	var b strings.Builder
+	for {
+		s, err := cl.r.ReadString('\n')
+		if err != nil {
+			cl.t.Fatal(err)
+		}
+		s = strings.TrimSuffix(s, "\r\n")
+		b.WriteString(s)
+		m := literalSuffix.FindStringSubmatch(s)
+		if m == nil {
+			return b.String()
+		}
+		n, _ := strconv.Atoi(m[1])
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(cl.r, buf); err != nil {
+			cl.t.Fatal(err)
+		}
+		b.WriteString("\r\n")
+		b.Write(buf)
+	}
+}
+
+// do sends a command and answers every response line, the tagged completion
+// last, with the tag removed from it.
+func (cl *client) do(format string, args ...interface{}) []string {
+	cl.tags++
+	tag := fmt.Sprintf("a%d", cl.tags)
+	fmt.Fprintf(cl.c, tag+" "+format+"\r\n", args...)
+	var lines []string
+	for {
+		s := cl.line()
+		if strings.HasPrefix(s, tag+" ") {
+			return append(lines, strings.TrimPrefix(s, tag+" "))
+		}
+		lines = append(lines, s)
+	}
+}
+
+// ok sends a command which must succeed, and answers its untagged responses.
+func (cl *client) ok(format string, args ...interface{}) []string {
+	lines := cl.do(format, args...)
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "OK") {
+		cl.t.Fatalf("%s failed: %s", fmt.Sprintf(format, args...), last)
+	}
+	return lines[:len(lines)-1]
+}
+
+func (cl *client) login() {
+	cl.ok("LOGIN bob secret")
+}
+
+// Given a server
+// When I log in, with and without literals
+// Then I expect only the right password to work.
+func TestLogin10(t *testing.T) {
+	cl := startServer(t)
+	if r := cl.do("LIST \"\" *"); !strings.HasPrefix(r[len(r)-1], "NO") {
+		t.Error("LIST before LOGIN should fail: ", r)
+	}
+	if r := cl.do("LOGIN bob wrong"); !strings.HasPrefix(r[0], "NO") {
+		t.Error("Wrong password accepted: ", r)
+	}
+
+	fmt.Fprintf(cl.c, "a99 LOGIN {3}\r\n")
+	if s := cl.line(); !strings.HasPrefix(s, "+") {
+		t.Fatal("Expected continuation; got ", s)
+	}
+	fmt.Fprintf(cl.c, "bob \"secret\"\r\n")
+	if s := cl.line(); !strings.HasPrefix(s, "a99 OK") {
+		t.Error("LOGIN with literal failed: ", s)
+	}
+	if r := cl.do("LOGOUT"); r[0] != "* BYE Logging out" {
+		t.Error("Expected BYE; got ", r)
+	}
+}
+
+// Given a server
+// When I send oversized literals before logging in
+// Then I expect them refused without an invitation, and the session to go on.
+func TestLogin20(t *testing.T) {
+	cl := startServer(t)
+	fmt.Fprintf(cl.c, "a1 LOGIN {4294967295}\r\n")
+	if s := cl.line(); !strings.HasPrefix(s, "a1 BAD") {
+		t.Error("Expected a1 BAD; got ", s)
+	}
+	fmt.Fprintf(cl.c, "a2 LOGIN {99999999999999999999}\r\n")
+	if s := cl.line(); !strings.HasPrefix(s, "a2 BAD") {
+		t.Error("Expected a2 BAD; got ", s)
+	}
+	cl.login()
+}
+
+// Given a store with nested folders
+// When I list them
+// Then I expect every folder, and the directories holding them.
+func TestList10(t *testing.T) {
+	cl := startServer(t)
+	cl.login()
+	expected := `* LIST () "/" "INBOX"|* LIST (\Noselect) "/" "Lists"|* LIST () "/" "Lists/golang"`
+	if got := strings.Join(cl.ok(`LIST "" "*"`), "|"); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+	expected = `* LIST () "/" "INBOX"|* LIST (\Noselect) "/" "Lists"`
+	if got := strings.Join(cl.ok(`LIST "" %%`), "|"); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+	if got := cl.ok(`STATUS inbox (MESSAGES UNSEEN)`); len(got) != 1 || got[0] != `* STATUS "INBOX" (MESSAGES 3 UNSEEN 2)` {
+		t.Error("STATUS wrong: ", got)
+	}
+}
+
+// Given a mailbox
+// When I select it and fetch from it
+// Then I expect read-only access with offset UIDs, flags, and content.
+func TestFetch10(t *testing.T) {
+	cl := startServer(t)
+	cl.login()
+	lines := cl.do("SELECT INBOX")
+	all := strings.Join(lines, "\n")
+	for _, want := range []string{"* 3 EXISTS", "* OK [UNSEEN 2]", "* OK [UIDVALIDITY ", "OK [READ-ONLY]"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("SELECT response lacks %q:\n%s", want, all)
+		}
+	}
+
+	lines = cl.ok("FETCH 1:* (UID FLAGS)")
+	second := strings.Index(inbox, "From carol")
+	expected := fmt.Sprintf(`* 1 FETCH (UID 1 FLAGS (\Answered \Seen))|* 2 FETCH (UID %d FLAGS ())|* 3 FETCH (UID %d FLAGS (\Flagged))`,
+		second+1, strings.LastIndex(inbox, "From alice")+1)
+	if got := strings.Join(lines, "|"); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+
+	lines = cl.ok("UID FETCH %d (RFC822.SIZE BODY.PEEK[HEADER.FIELDS (Subject)])", second+1)
+	expected = fmt.Sprintf("* 2 FETCH (UID %d RFC822.SIZE 410 BODY[HEADER.FIELDS (SUBJECT)] {19}\r\nSubject: Report\r\n\r\n)", second+1)
+	if len(lines) != 1 || lines[0] != expected {
+		t.Errorf("Expected %q; got %q", expected, lines)
+	}
+
+	lines = cl.ok("FETCH 1 (BODY[TEXT])")
+	expected = "* 1 FETCH (BODY[TEXT] {43}\r\nShall we?\r\nFrom experience, noon is best.\r\n)"
+	if len(lines) != 1 || lines[0] != expected {
+		t.Errorf("Expected %q; got %q", expected, lines)
+	}
+
+	lines = cl.ok("FETCH 2 (BODYSTRUCTURE BODY[1] BODY[2]<0.5> BODY[2.MIME])")
+	expected = `* 2 FETCH (BODYSTRUCTURE (("TEXT" "PLAIN" ("CHARSET" "us-ascii") NIL NIL "7BIT" 13 0 NIL NIL)` +
+		`("APPLICATION" "OCTET-STREAM" NIL NIL NIL "BASE64" 8 NIL ("ATTACHMENT" ("FILENAME" "report.bin"))) "MIXED" ("BOUNDARY" "XYZ"))` +
+		" BODY[1] {13}\r\nSee attached. BODY[2]<0> {5}\r\nSGVsb" +
+		" BODY[2.MIME] {133}\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"report.bin\"\r\nContent-Transfer-Encoding: base64\r\n\r\n)"
+	if len(lines) != 1 || lines[0] != expected {
+		t.Errorf("Expected %q; got %q", expected, lines)
+	}
+
+	lines = cl.ok("FETCH 1 (ENVELOPE)")
+	expected = `* 1 FETCH (ENVELOPE ("Mon, 2 Jan 2006 15:04:05 +0000" "Lunch" (("Alice" NIL "alice" "example.com")) (("Alice" NIL "alice" "example.com")) (("Alice" NIL "alice" "example.com")) (("Bob" NIL "bob" "example.com")) NIL NIL NIL "<1@example.com>"))`
+	if len(lines) != 1 || lines[0] != expected {
+		t.Errorf("Expected %q; got %q", expected, lines)
+	}
+
+	for i := 1; i <= 3; i++ {
+		lines = cl.ok("FETCH %d (RFC822.SIZE BODY[])", i)
+		size := regexp.MustCompile(`RFC822.SIZE (\d+) BODY\[\] \{(\d+)\}`).FindStringSubmatch(lines[0])
+		if size == nil || size[1] != size[2] {
+			t.Errorf("RFC822.SIZE disagrees with BODY[]: %q", lines[0])
+		}
+	}
+
+	if r := cl.do("STORE 1 +FLAGS (\\Deleted)"); !strings.HasPrefix(r[0], "NO") {
+		t.Error("STORE should be refused: ", r)
+	}
+}
+
+// Given a selected mailbox
+// When I fetch partial ranges at or beyond the limits of a number
+// Then I expect empty data or BAD, and the server to keep serving.
+func TestFetch20(t *testing.T) {
+	cl := startServer(t)
+	cl.login()
+	cl.ok("EXAMINE INBOX")
+	if r := cl.do("FETCH 1 (BODY.PEEK[]<5.9223372036854775807>)"); !strings.HasPrefix(r[0], "BAD") {
+		t.Error("Oversized partial count should be rejected: ", r)
+	}
+	lines := cl.ok("FETCH 1 (BODY.PEEK[]<4294967295.4294967295>)")
+	if len(lines) != 1 || lines[0] != "* 1 FETCH (BODY[]<4294967295> {0}\r\n)" {
+		t.Errorf("Expected empty data beyond the message; got %q", lines)
+	}
+	lines = cl.ok("FETCH 1 (BODY.PEEK[]<5.4294967295>)")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "* 1 FETCH (BODY[]<5> {") {
+		t.Errorf("Expected the rest of the message; got %q", lines)
+	}
+}
+
+// Given a selected mailbox
+// When a message is appended, and later the file is rewritten in place
+// Then I expect UIDVALIDITY kept across the append, and changed by the rewrite.
+func TestSelect10(t *testing.T) {
+	cl := startServer(t)
+	cl.login()
+	validity := func() string {
+		for _, s := range cl.ok("EXAMINE INBOX") {
+			if strings.HasPrefix(s, "* OK [UIDVALIDITY ") {
+				return s
+			}
+		}
+		t.Fatal("No UIDVALIDITY")
+		return ""
+	}
+
+	path := filepath.Join(cl.root, "INBOX")
+	first := validity()
+	env := mbox.Envelope{Sender: "dave@example.com"}
+	if err := mbox.Append(path, env, strings.NewReader("Subject: Late\n\nSorry.\n")); err != nil {
+		t.Fatal(err)
+	}
+	if second := validity(); second != first {
+		t.Errorf("UIDVALIDITY changed by appending: %s, then %s", first, second)
+	}
+
+	second := strings.Index(inbox, "From carol")
+	third := strings.LastIndex(inbox, "From alice")
+	if err := os.WriteFile(path, []byte(inbox[:second]+inbox[third:]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if last := validity(); last == first {
+		t.Error("UIDVALIDITY unchanged by rewriting in place: ", last)
+	}
+}
+
+// Given a selected mailbox
+// When another program truncates its file, and I fetch a message
+// Then I expect my session ended, if anything, and the server to keep serving.
+func TestFetch30(t *testing.T) {
+	cl := startServer(t)
+	cl.login()
+	cl.ok("EXAMINE INBOX")
+	if err := os.Truncate(filepath.Join(cl.root, "INBOX"), 0); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(cl.c, "a FETCH 1 (BODY.PEEK[])\r\n")
+	for {
+		s, err := cl.r.ReadString('\n')
+		if err == io.EOF || strings.HasPrefix(s, "a ") {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := net.Dial("tcp", cl.c.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	cl = &client{t: t, root: cl.root, c: c, r: bufio.NewReader(c)}
+	if greeting := cl.line(); !strings.HasPrefix(greeting, "* OK") {
+		t.Fatal("Bad greeting: ", greeting)
+	}
+	cl.login()
+	cl.ok("EXAMINE INBOX")
+}
+
+// Given a mailbox
+// When I search it
+// Then I expect the matching sequence numbers or UIDs.
+func TestSearch10(t *testing.T) {
+	cl := startServer(t)
+	cl.login()
+	cl.ok("EXAMINE INBOX")
+	third := strings.LastIndex(inbox, "From alice") + 1
+	searches := map[string]string{
+		"FROM alice":                     "* SEARCH 1 3",
+		"UNSEEN":                         "* SEARCH 2 3",
+		"OR FLAGGED ANSWERED":            "* SEARCH 1 3",
+		"NOT (FROM alice) BODY attached": "* SEARCH 2",
+		"SINCE 3-Jan-2006 SUBJECT lunch": "* SEARCH 3",
+		"2:* SMALLER 300":                "* SEARCH 3",
+		"CHARSET UTF-8 TEXT \"noon\"":    "* SEARCH 1",
+		"HEADER Content-Type mixed":      "* SEARCH 2",
+	}
+	for query, expected := range searches {
+		if got := cl.ok("SEARCH %s", query); len(got) != 1 || got[0] != expected {
+			t.Errorf("SEARCH %s: expected %s; got %s", query, expected, got)
+		}
+	}
+	if got := cl.ok("UID SEARCH SUBJECT Re:"); len(got) != 1 || got[0] != "* SEARCH "+strconv.Itoa(third) {
+		t.Error("UID SEARCH wrong: ", got)
+	}
+	if r := cl.do("SEARCH BOGUS"); !strings.HasPrefix(r[0], "BAD") {
+		t.Error("Unknown search key should fail: ", r)
+	}
+}
+
+// Given a mailbox whose UIDVALIDITY is cached
+// When it grows, grows past 4 GiB, or holds messages at offsets 4 GiB apart
+// Then I expect UIDVALIDITY kept only while the same UIDs still apply.
+func TestValidityCache10(t *testing.T) {
+	var c validityCache
+	marks := []mark{{offset: 0, sum: 1}, {offset: 100, sum: 2}}
+	first := c.validity("inbox", 1, true, 7, marks[:1])
+	if v := c.validity("inbox", 1, true, 8, marks); v != first {
+		t.Errorf("UIDVALIDITY changed by appending: %d, then %d", first, v)
+	}
+	grown := append(marks, mark{offset: 5 << 30, sum: 3})
+	if v := c.validity("inbox", 1, false, 7, grown); v == first {
+		t.Error("UIDVALIDITY unchanged when UIDs became sequence numbers: ", v)
+	}
+
+	var d validityCache
+	first = d.validity("inbox", 1, false, 7, []mark{{offset: 1 << 32, sum: 1}})
+	if v := d.validity("inbox", 1, false, 7, []mark{{offset: 2 << 32, sum: 1}}); v == first {
+		t.Error("UIDVALIDITY unchanged though the message moved by 4 GiB: ", v)
+	}
+}
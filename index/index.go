@@ -0,0 +1,346 @@
+// vim: ts=8 noexpandtab ai
+
+// Package index maintains a full-text inverted index over an mbox file.  The
+// index is stored in a file beside the mailbox, named after it with an ".idx"
+// suffix, and may be brought up to date incrementally as messages are appended
+// to the mailbox.
+//
+// Subjects, addresses in the From, To and Cc headers, and the decoded text of
+// message bodies are indexed.  Searches answer the byte offsets of matching
+// messages, ranked by relevance; hand these to mbox.CreateMboxStreamAt() or
+// mbox.MappedMbox.MessageAt() to retrieve the messages themselves.
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// tailLength sets how many bytes at the end of the indexed portion of the
+// mailbox are checksummed, to detect mailboxes rewritten since indexing.
+const tailLength = 4096
+
+// subjectWeight counts each subject word as this many body words.
+const subjectWeight = 2
+
+// An Index maps words to the messages containing them.
+type Index struct {
+	// Size records how many bytes of the mailbox have been indexed.
+	Size int64
+
+	// Tail checksums the last bytes indexed.
+	Tail uint32
+
+	// Docs describes each indexed message, in mailbox order.
+	Docs []Doc
+
+	// Postings lists, for each word, the messages containing it.
+	Postings map[string][]Posting
+
+	mailbox string
+}
+
+// A Doc describes one indexed message.
+type Doc struct {
+	Offset int64
+	Words  int
+}
+
+// A Posting records how often a word occurs in a message, identified by its
+// position in Docs.
+type Posting struct {
+	Doc  int
+	Freq int
+}
+
+// A Hit identifies a message matching a search.
+type Hit struct {
+	Offset int64
+	Score  float64
+}
+
+// Path answers where the index for the named mailbox is stored.
+func Path(mailbox string) string {
+	return mailbox + ".idx"
+}
+
+// Build indexes the named mailbox from scratch and saves the result.
+func Build(mailbox string) (*Index, error) {
+	ix := &Index{Postings: make(map[string][]Posting), mailbox: mailbox}
+	if _, err := ix.catchUp(); err != nil {
+		return nil, err
+	}
+	return ix, ix.Save()
+}
+
+// Open loads the saved index of the named mailbox, without checking whether
+// it is up to date.
+func Open(mailbox string) (*Index, error) {
+	f, err := os.Open(Path(mailbox))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ix := &Index{mailbox: mailbox}
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(ix); err != nil {
+		return nil, fmt.Errorf("%s: %v", Path(mailbox), err)
+	}
+	if ix.Postings == nil {
+		ix.Postings = make(map[string][]Posting)
+	}
+	return ix, nil
+}
+
+// Update loads the saved index of the named mailbox and indexes any messages
+// appended since it was saved.  If no index exists yet, or the mailbox has been
+// rewritten since, the index is rebuilt from scratch.  The result is saved if
+// anything changed.
+func Update(mailbox string) (*Index, error) {
+	ix, err := Open(mailbox)
+	if err != nil {
+		return Build(mailbox)
+	}
+	changed, err := ix.catchUp()
+	if err != nil || !changed {
+		return ix, err
+	}
+	return ix, ix.Save()
+}
+
+// Save writes the index beside its mailbox, replacing any previous index
+// atomically.
+func (ix *Index) Save() (err error) {
+	path := Path(ix.mailbox)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if err = gob.NewEncoder(w).Encode(ix); err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Len answers the number of messages indexed.
+func (ix *Index) Len() int {
+	return len(ix.Docs)
+}
+
+// Search answers the messages containing any of the words in the query,
+// ranked best first using BM25.  At most limit hits are returned; a limit of
+// zero or less returns them all.
+func (ix *Index) Search(query string, limit int) []Hit {
+	if len(ix.Docs) == 0 {
+		return nil
+	}
+
+	const k1, b = 1.2, 0.75
+	total := 0
+	for _, d := range ix.Docs {
+		total += d.Words
+	}
+	avg := float64(total) / float64(len(ix.Docs))
+	if avg == 0 {
+		avg = 1
+	}
+
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, word := range tokenize(query) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		postings := ix.Postings[word]
+		n := float64(len(postings))
+		idf := math.Log(1 + (float64(len(ix.Docs))-n+0.5)/(n+0.5))
+		for _, p := range postings {
+			tf := float64(p.Freq)
+			dl := float64(ix.Docs[p.Doc].Words)
+			scores[p.Doc] += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*dl/avg))
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for doc, score := range scores {
+		hits = append(hits, Hit{Offset: ix.Docs[doc].Offset, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Offset < hits[j].Offset
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// catchUp indexes every message from ix.Size to the end of the mailbox, and
+// answers whether the index changed.  If the mailbox has shrunk, or the bytes
+// last indexed have changed, it starts again from scratch.  It holds the
+// mailbox lock while checking and reading, so neither a delivery nor a rewrite
+// in progress is ever half indexed.  A mailbox this process may not write
+// can't be locked, and is read as it stands.
+func (ix *Index) catchUp() (changed bool, err error) {
+	f, err := os.OpenFile(ix.mailbox, os.O_RDWR, 0)
+	writable := err == nil
+	if os.IsPermission(err) {
+		f, err = os.Open(ix.mailbox)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if writable {
+		lock, err := mbox.LockMailbox(f, mbox.DefaultLockTimeout)
+		if err != nil {
+			return false, err
+		}
+		defer lock.Unlock()
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := fi.Size()
+	if ix.Size > 0 {
+		tail, err := checksumTail(f, ix.Size)
+		switch {
+		case size < ix.Size || err != nil || tail != ix.Tail:
+			*ix = Index{Postings: make(map[string][]Posting), mailbox: ix.mailbox}
+		case size == ix.Size:
+			return false, nil
+		}
+	}
+
+	// Append may have added blank lines to terminate the last message we
+	// saw; skip past them to the next From marker.
+	start := ix.Size
+	br := bufio.NewReader(io.NewSectionReader(f, start, size-start))
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil || len(bytes.TrimSpace(line)) != 0 {
+			break
+		}
+		start += int64(len(line))
+	}
+
+	if start < size {
+		// If only whitespace follows, CreateMboxStreamAt answers io.EOF;
+		// there's simply nothing new.
+		s, err := mbox.CreateMboxStreamAt(io.NewSectionReader(f, 0, size), start)
+		for err == nil {
+			var msg *mbox.Message
+			if msg, err = s.ReadMessage(); err == nil {
+				err = ix.add(msg)
+			}
+		}
+		if err != io.EOF {
+			return false, err
+		}
+	}
+
+	ix.Size = size
+	ix.Tail, err = checksumTail(f, size)
+	return true, err
+}
+
+// add indexes a single message, consuming its body.
+func (ix *Index) add(msg *mbox.Message) error {
+	counts := make(map[string]int)
+	h := msg.MailHeader()
+
+	for _, w := range tokenize(mbox.DecodeHeader(h.Get("Subject"))) {
+		counts[w] += subjectWeight
+	}
+	for _, key := range []string{"From", "To", "Cc"} {
+		addrs, err := h.AddressList(key)
+		if err != nil {
+			for _, v := range h[key] {
+				for _, w := range tokenize(v) {
+					counts[w]++
+				}
+			}
+			continue
+		}
+		for _, a := range addrs {
+			counts[strings.ToLower(a.Address)]++
+			for _, w := range tokenize(a.Name + " " + a.Address) {
+				counts[w]++
+			}
+		}
+	}
+
+	parts, _ := msg.Parts()
+	for _, w := range tokenize(mbox.Text(parts)) {
+		counts[w]++
+	}
+
+	doc := len(ix.Docs)
+	words := 0
+	for w, n := range counts {
+		ix.Postings[w] = append(ix.Postings[w], Posting{Doc: doc, Freq: n})
+		words += n
+	}
+	ix.Docs = append(ix.Docs, Doc{Offset: msg.Offset(), Words: words})
+	return nil
+}
+
+// tokenize splits text into lower-cased words of letters and digits.  Words
+// shorter than two characters are dropped.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := words[:0]
+	for _, w := range words {
+		if len(w) > 1 {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// checksumTail checksums the bytes of the mailbox just before the given
+// offset.
+func checksumTail(f io.ReaderAt, end int64) (uint32, error) {
+	start := end - tailLength
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, end-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
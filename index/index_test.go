@@ -0,0 +1,207 @@
+// vim: ts=8 noexpandtab ai
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+const mailbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: Alice <alice@example.com>
+To: Bob <bob@example.com>
+Subject: Quarterly budget
+
+The budget for the quarter is attached.  Budget, budget, budget.
+
+From bob@example.com Tue Jan  3 09:00:00 2006
+From: Bob <bob@example.com>
+To: Alice <alice@example.com>
+Subject: Re: Quarterly budget
+
+Looks fine to me.
+`
+
+// subjectAt reads the message at the given offset and answers its subject.
+func subjectAt(t *testing.T, path string, offset int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := mbox.CreateMboxStreamAt(f, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := s.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.MailHeader().Get("Subject")
+}
+
+// Given a mailbox
+// When I build an index and search it
+// Then I expect ranked hits whose offsets lead to the right messages.
+func TestIndex10(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Build(path)
+	if err != nil {
+		t.Fatal("TestIndex10: ", err)
+	}
+	if ix.Len() != 2 {
+		t.Fatal("Expected 2 messages indexed; got ", ix.Len())
+	}
+
+	hits := ix.Search("budget", 0)
+	if len(hits) != 2 {
+		t.Fatal("Expected 2 hits; got ", hits)
+	}
+	if subjectAt(t, path, hits[0].Offset) != "Quarterly budget" {
+		t.Error("Message mentioning budget most often should rank first")
+	}
+	if hits := ix.Search("fine", 1); len(hits) != 1 || subjectAt(t, path, hits[0].Offset) != "Re: Quarterly budget" {
+		t.Error("Expected a single hit for 'fine'; got ", hits)
+	}
+	if hits := ix.Search("alice@example.com", 0); len(hits) != 2 {
+		t.Error("Expected addresses to be indexed; got ", hits)
+	}
+}
+
+// Given an indexed mailbox
+// When I append a message and update the index
+// Then I expect only the new message to be indexed, and findable.
+func TestIndex20(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(path); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "From: Carol <carol@example.com>\nSubject: Offsite\n\nWho is bringing the projector?\n"
+	if err := mbox.Append(path, mbox.Envelope{Sender: "carol@example.com"}, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Update(path)
+	if err != nil {
+		t.Fatal("TestIndex20: ", err)
+	}
+	if ix.Len() != 3 {
+		t.Fatal("Expected 3 messages indexed; got ", ix.Len())
+	}
+	hits := ix.Search("projector", 0)
+	if len(hits) != 1 || subjectAt(t, path, hits[0].Offset) != "Offsite" {
+		t.Error("Expected to find the appended message; got ", hits)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil || reloaded.Len() != 3 {
+		t.Error("Updated index should have been saved: ", err)
+	}
+}
+
+// Given an indexed mailbox, locked while a message is half delivered
+// When I update the index, and the delivery then completes
+// Then I expect the whole message indexed, once the lock is released.
+func TestIndex30(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(path); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lock, err := mbox.LockMailbox(f, time.Second)
+	if err != nil {
+		t.Fatal("TestIndex30: ", err)
+	}
+	f.WriteString("From carol@example.com Mon Jan  9 10:00:00 2006\nSubject: Offsite\n\nWho is bringing\n")
+
+	done := make(chan *Index)
+	go func() {
+		ix, err := Update(path)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- ix
+	}()
+	time.Sleep(300 * time.Millisecond)
+	f.WriteString("the projector?\n\n")
+	lock.Unlock()
+
+	ix := <-done
+	if ix == nil {
+		return
+	}
+	if hits := ix.Search("projector", 0); len(hits) != 1 {
+		t.Error("Expected the completed message indexed; got ", hits)
+	}
+}
+
+// Given an indexed mailbox
+// When it's rewritten in place to the same length, and I update the index
+// Then I expect the index rebuilt to match.
+func TestIndex40(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(path); err != nil {
+		t.Fatal(err)
+	}
+
+	rewritten := strings.NewReplacer("budget", "ledger", "Budget", "Ledger").Replace(mailbox)
+	if err := os.WriteFile(path, []byte(rewritten), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Update(path)
+	if err != nil {
+		t.Fatal("TestIndex40: ", err)
+	}
+	if hits := ix.Search("budget", 0); len(hits) != 0 {
+		t.Error("Expected the old words forgotten; got ", hits)
+	}
+	if hits := ix.Search("ledger", 0); len(hits) != 2 {
+		t.Error("Expected both messages found by their new words; got ", hits)
+	}
+}
+
+// Given an indexed mailbox
+// When only whitespace is appended, and I update the index
+// Then I expect nothing new, and no error.
+func TestIndex50(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(mailbox+"\n  \n \t"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Update(path)
+	if err != nil {
+		t.Fatal("TestIndex50: ", err)
+	}
+	if ix.Len() != 2 {
+		t.Error("Expected 2 messages indexed; got ", ix.Len())
+	}
+}
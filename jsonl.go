@@ -0,0 +1,149 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// JSONLOptions selects what ExportJSONL includes for each message.
+type JSONLOptions struct {
+	// Headers lists the header fields to include, compared without
+	// regard to case.  A nil slice includes every field.
+	Headers []string
+
+	// Body includes the body of each message.
+	Body bool
+
+	// Decode converts each body to text, as Text does, and decodes RFC
+	// 2047 encoded words in header values.  Otherwise, bodies appear as
+	// they do in the mailbox, unescaped according to Dialect and without
+	// the blank line separating them from the next message.
+	Decode bool
+
+	// Attachments includes the content type, file name and size of each
+	// attachment.
+	Attachments bool
+
+	// Dialect tells how From lines in bodies were escaped.
+	Dialect Dialect
+}
+
+// A jsonlMessage is the object written for each message.
+type jsonlMessage struct {
+	Offset      int64             `json:"offset"`
+	Size        int64             `json:"size"`
+	Sender      string            `json:"sender"`
+	Date        *time.Time        `json:"date,omitempty"`
+	Headers     []Field           `json:"headers"`
+	Body        *string           `json:"body,omitempty"`
+	Attachments []jsonlAttachment `json:"attachments,omitempty"`
+}
+
+type jsonlAttachment struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Size        int    `json:"size"`
+}
+
+// ExportJSONL writes each remaining message of the stream to w as a line of
+// JSON, and answers how many it wrote.  Every object holds the message's
+// offset and size in bytes, its envelope sender and date, and its header
+// fields as a list of name and value pairs, in order and including
+// duplicates; opts selects what else to include, and may be nil.
+func ExportJSONL(s *MboxStream, w io.Writer, opts *JSONLOptions) (n int, err error) {
+	if opts == nil {
+		opts = &JSONLOptions{}
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return n, bw.Flush()
+		}
+		if err != nil {
+			return n, err
+		}
+		record, err := jsonlRecord(msg, opts)
+		if err != nil {
+			return n, err
+		}
+		if err = enc.Encode(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// jsonlRecord gathers what ExportJSONL writes for a single message.
+func jsonlRecord(msg *Message, opts *JSONLOptions) (*jsonlMessage, error) {
+	body, err := io.ReadAll(msg.BodyReader())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &jsonlMessage{
+		Offset:  msg.Offset(),
+		Size:    int64(len(msg.RawHeader()) + len(body)),
+		Headers: []Field{},
+	}
+	env, _ := msg.Envelope()
+	r.Sender = env.Sender
+	if !env.Date.IsZero() {
+		r.Date = &env.Date
+	}
+
+	for _, f := range msg.Fields() {
+		if opts.Headers != nil && !containsFold(opts.Headers, f.Name) {
+			continue
+		}
+		if opts.Decode {
+			f.Value = DecodeHeader(f.Value)
+		}
+		r.Headers = append(r.Headers, f)
+	}
+
+	var parts []Part
+	if opts.Attachments || opts.Body && opts.Decode {
+		// A malformed MIME structure still yields what parts it can.
+		parts, _ = Parts(msg.MailHeader(), bytes.NewReader(body))
+	}
+	if opts.Body {
+		var text string
+		if opts.Decode {
+			text = Text(parts)
+		} else {
+			var b bytes.Buffer
+			if err := writeEML(&b, nil, bytes.NewReader(body), opts.Dialect); err != nil {
+				return nil, err
+			}
+			text = b.String()
+		}
+		r.Body = &text
+	}
+	if opts.Attachments {
+		for _, p := range parts {
+			if p.Attachment {
+				r.Attachments = append(r.Attachments, jsonlAttachment{p.ContentType, p.Filename, p.Size})
+			}
+		}
+	}
+	return r, nil
+}
+
+// containsFold answers true if the list holds the name, compared without
+// regard to case.
+func containsFold(list []string, name string) bool {
+	for _, s := range list {
+		if equalFold([]byte(name), s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Given a mailbox whose first message repeats headers
+// When I export it as JSON Lines, selecting some headers and the body
+// Then I expect one object per message, with duplicates kept in order.
+func TestExportJSONL10(t *testing.T) {
+	withOpenMboxStream(t, "TestExportJSONL10", mboxWithRepeatedHeaders, func(mr *MboxStream) {
+		var out bytes.Buffer
+		opts := &JSONLOptions{Headers: []string{"received", "Subject"}, Body: true}
+		n, err := ExportJSONL(mr, &out, opts)
+		if err != nil || n != 2 {
+			t.Fatal("Expected 2 messages; got ", n, err)
+		}
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if len(lines) != 2 {
+			t.Fatal("Expected 2 lines; got ", len(lines))
+		}
+
+		var first jsonlMessage
+		if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+			t.Fatal(err)
+		}
+		if first.Offset != 0 || first.Sender != "foo@bar.com" || first.Body == nil || *first.Body != "Body text.\n" {
+			t.Errorf("First message wrong: %s", lines[0])
+		}
+		if len(first.Headers) != 2 || first.Headers[1].Value != "from b.bar.com by c.bar.com" {
+			t.Errorf("Headers wrong: %+v", first.Headers)
+		}
+		if first.Size != int64(strings.Index(mboxWithRepeatedHeaders, "From foo@bar.com\nSubject")) {
+			t.Error("Size wrong: ", first.Size)
+		}
+	})
+}
+
+// Given a multipart message
+// When I export it with decoding and attachments
+// Then I expect decoded text and attachment metadata.
+func TestExportJSONL20(t *testing.T) {
+	mailbox := "From foo@bar.com\n" +
+		"Subject: =?utf-8?q?caf=C3=A9?=\n" +
+		"MIME-Version: 1.0\n" +
+		"Content-Type: multipart/mixed; boundary=\"XYZ\"\n\n" +
+		multipartBody
+	withOpenMboxStream(t, "TestExportJSONL20", mailbox, func(mr *MboxStream) {
+		var out bytes.Buffer
+		opts := &JSONLOptions{Body: true, Decode: true, Attachments: true}
+		if _, err := ExportJSONL(mr, &out, opts); err != nil {
+			t.Fatal(err)
+		}
+		var m jsonlMessage
+		if err := json.Unmarshal(out.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Headers[0].Value != "café" {
+			t.Errorf("Subject not decoded: %q", m.Headers[0].Value)
+		}
+		if m.Body == nil || *m.Body != "Café au lait" {
+			t.Errorf("Body not decoded: %s", out.String())
+		}
+		if len(m.Attachments) != 1 || m.Attachments[0].Filename != "invoice_1.pdf" || m.Attachments[0].Size != 12 {
+			t.Errorf("Attachments wrong: %+v", m.Attachments)
+		}
+	})
+}
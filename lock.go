@@ -0,0 +1,216 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLockTimeout is returned when a mailbox lock could not be acquired in the
+// time allowed.
+var ErrLockTimeout = errors.New("Timed out waiting for mailbox lock")
+
+// ErrLockLost is returned when another process has replaced a dotlock it
+// judged stale.
+var ErrLockLost = errors.New("Mailbox dotlock was taken over by another process")
+
+// DefaultLockTimeout bounds how long Append and friends wait for a mailbox
+// lock.
+const DefaultLockTimeout = 30 * time.Second
+
+// staleDotlockAge sets how old a dotlock file must be before it is presumed
+// abandoned and removed.  This matches procmail's default.
+const staleDotlockAge = 8 * time.Minute
+
+// lockRetryInterval sets how long to wait between lock attempts.
+const lockRetryInterval = 100 * time.Millisecond
+
+// A MailboxLock represents exclusive access to a mailbox file, held in the
+// manner procmail and mutt expect: a dotlock file first, followed by fcntl and
+// flock locks on the file itself where the platform supports them.
+type MailboxLock struct {
+	f       *os.File
+	dotlock string
+	dotInfo os.FileInfo // identifies our dotlock, should another replace it
+	kernel  bool
+}
+
+// LockMailbox acquires all the locks conventionally used to guard an mbox
+// file, waiting up to timeout for other processes to release theirs.  The file
+// must be open for writing.  If the mailbox's directory doesn't permit
+// creating a dotlock file, dotlocking is skipped and only kernel locks are
+// taken.
+func LockMailbox(f *os.File, timeout time.Duration) (*MailboxLock, error) {
+	l := &MailboxLock{f: f}
+	deadline := time.Now().Add(timeout)
+
+	dotlock := f.Name() + ".lock"
+	err := retryLock(deadline, func() (busy bool, err error) {
+		l.dotInfo, busy, err = createDotlock(dotlock)
+		return
+	})
+	switch {
+	case err == nil:
+		l.dotlock = dotlock
+	case !os.IsPermission(err):
+		return nil, err
+	}
+
+	err = retryLock(deadline, func() (bool, error) {
+		return lockFile(f)
+	})
+	if err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	l.kernel = true
+	return l, nil
+}
+
+// Unlock releases the locks in the reverse order of their acquisition.
+func (l *MailboxLock) Unlock() error {
+	var err error
+	if l.kernel {
+		err = unlockFile(l.f)
+		l.kernel = false
+	}
+	if l.dotlock != "" {
+		// Someone may have judged our dotlock stale and replaced it
+		// with their own; that one isn't ours to remove.
+		if l.ownsDotlock() {
+			if e := os.Remove(l.dotlock); err == nil {
+				err = e
+			}
+		}
+		l.dotlock = ""
+	}
+	return err
+}
+
+// Refresh renews the dotlock's modification time, so that other programs
+// don't judge it stale.  Anyone holding a mailbox for longer than a few
+// minutes must call it periodically.  If the dotlock has been replaced
+// meanwhile, Refresh fails with ErrLockLost.
+func (l *MailboxLock) Refresh() error {
+	if l.dotlock == "" {
+		return nil
+	}
+	if !l.ownsDotlock() {
+		return ErrLockLost
+	}
+	now := time.Now()
+	if err := os.Chtimes(l.dotlock, now, now); err != nil {
+		return err
+	}
+	fi, err := os.Stat(l.dotlock)
+	if err == nil {
+		l.dotInfo = fi
+	}
+	return err
+}
+
+// ownsDotlock answers true if the dotlock file is still the one we created.
+// Since a replacement may reuse our dotlock's inode, its modification time
+// must also be the one we last gave it.
+func (l *MailboxLock) ownsDotlock() bool {
+	fi, err := os.Stat(l.dotlock)
+	return err == nil && os.SameFile(fi, l.dotInfo) && fi.ModTime().Equal(l.dotInfo.ModTime())
+}
+
+// openLocked opens the named mailbox with the given flags and locks it.  Once
+// the locks are held, it confirms that the path still names the file it
+// opened: while it waited, another process may have rewritten the mailbox and
+// renamed the result into place, leaving this process holding the old, unlinked
+// file.  In that case it lets go and tries again with the new file.
+func openLocked(path string, flag int, timeout time.Duration) (*os.File, *MailboxLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, flag, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		lock, err := LockMailbox(f, time.Until(deadline))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		held, err := f.Stat()
+		if err == nil {
+			var named os.FileInfo
+			named, err = os.Stat(path)
+			if err == nil && os.SameFile(held, named) {
+				return f, lock, nil
+			}
+			if os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+				err = nil
+			}
+		}
+		lock.Unlock()
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// moveTo transfers the kernel locks to another file, such as one which has
+// just been renamed over the original mailbox.  The dotlock is held
+// throughout, so the mailbox is never left unguarded.
+func (l *MailboxLock) moveTo(f *os.File, timeout time.Duration) error {
+	err := retryLock(time.Now().Add(timeout), func() (bool, error) {
+		return lockFile(f)
+	})
+	if err != nil {
+		return err
+	}
+	if l.kernel {
+		unlockFile(l.f)
+	}
+	l.f = f
+	l.kernel = true
+	return nil
+}
+
+// retryLock repeatedly attempts to take a lock until it succeeds, fails
+// outright, or the deadline passes.  The attempt function answers true if the
+// lock is currently held by someone else.
+func retryLock(deadline time.Time, attempt func() (busy bool, err error)) error {
+	for {
+		busy, err := attempt()
+		if err != nil || !busy {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// createDotlock attempts to create the dotlock file exclusively, answering
+// its identity if it succeeds.  Dotlocks older than staleDotlockAge are
+// removed so the attempt may be retried.
+func createDotlock(path string) (fi os.FileInfo, busy bool, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+		fi, err = f.Stat()
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+		return fi, false, err
+	}
+	if !os.IsExist(err) {
+		return nil, false, err
+	}
+	if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleDotlockAge {
+		os.Remove(path)
+	}
+	return nil, true, nil
+}
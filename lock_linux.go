@@ -0,0 +1,49 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build linux
+
+package mbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an fcntl write lock, then an flock exclusive lock, on the
+// whole file.  Neither call blocks; a lock held elsewhere is reported as busy.
+func lockFile(f *os.File) (busy bool, err error) {
+	fd := int(f.Fd())
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0}
+	if err := syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk); err != nil {
+		return isBusy(err), busyError(err)
+	}
+	if err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lk.Type = syscall.F_UNLCK
+		syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk)
+		return isBusy(err), busyError(err)
+	}
+	return false, nil
+}
+
+// unlockFile releases the locks taken by lockFile, in reverse order.
+func unlockFile(f *os.File) error {
+	fd := int(f.Fd())
+	err := syscall.Flock(fd, syscall.LOCK_UN)
+	lk := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: 0}
+	if e := syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk); err == nil {
+		err = e
+	}
+	return err
+}
+
+func isBusy(err error) bool {
+	return err == syscall.EAGAIN || err == syscall.EACCES || err == syscall.EWOULDBLOCK
+}
+
+// busyError suppresses errors which merely indicate contention.
+func busyError(err error) error {
+	if isBusy(err) {
+		return nil
+	}
+	return err
+}
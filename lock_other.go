@@ -0,0 +1,17 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build !linux
+
+package mbox
+
+import "os"
+
+// lockFile does nothing on platforms where this package doesn't support kernel
+// file locks; the dotlock alone guards the mailbox.
+func lockFile(f *os.File) (busy bool, err error) {
+	return false, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,92 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// MailHeader answers the message's headers as a net/mail Header.  Unlike
+// Headers(), field names are canonicalized, folded values are unfolded, and
+// repeated fields (such as Received) keep every occurrence, in order.
+func (m *Message) MailHeader() mail.Header {
+	if m.mailHeader == nil {
+		m.mailHeader = parseMailHeader(m.headerBlock())
+	}
+	return m.mailHeader
+}
+
+// MailMessage converts the message into a net/mail Message, so that it may be
+// handled like any other mail.  The Body reads the message body, stopping at
+// the next message in the mailbox; as with BodyReader(), it must be read
+// completely before reading the next message.
+func (m *Message) MailMessage() *mail.Message {
+	return &mail.Message{
+		Header: m.MailHeader(),
+		Body:   m.BodyReader(),
+	}
+}
+
+// Date parses the message's Date header.
+func (m *Message) Date() (time.Time, error) {
+	return m.MailHeader().Date()
+}
+
+// AddressList parses the named header as a list of addresses.
+func (m *Message) AddressList(key string) ([]*mail.Address, error) {
+	return m.MailHeader().AddressList(key)
+}
+
+// MessageID answers the message's Message-ID header, without angle brackets,
+// or an empty string if it has none.
+func (m *Message) MessageID() string {
+	return string(trimAngles([]byte(m.MailHeader().Get("Message-ID"))))
+}
+
+// MailHeader answers the message's headers as a net/mail Header, as
+// Message.MailHeader does.  The result is freshly allocated on each call.
+func (v *View) MailHeader() mail.Header {
+	return parseMailHeader(v.Header)
+}
+
+// parseMailHeader builds a mail.Header from a raw header block.  It accepts
+// anything forEachField does, so unlike mail.ReadMessage it never fails.
+func parseMailHeader(block []byte) mail.Header {
+	h := make(mail.Header)
+	forEachField(block, func(name, value []byte) bool {
+		key := textproto.CanonicalMIMEHeaderKey(string(bytes.TrimSpace(name)))
+		h[key] = append(h[key], unfold(value))
+		return true
+	})
+	return h
+}
+
+// unfold joins the lines of a folded header value with single spaces.
+func unfold(value []byte) string {
+	lines := strings.Split(string(value), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, " ")
+}
+
+// A Field is a single header field, as it appears in the message.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Fields answers the message's header fields in the order they appear,
+// keeping any which repeat.  Folded values are unfolded as for MailHeader().
+func (m *Message) Fields() []Field {
+	var fields []Field
+	forEachField(m.headerBlock(), func(name, value []byte) bool {
+		fields = append(fields, Field{string(bytes.TrimSpace(name)), unfold(value)})
+		return true
+	})
+	return fields
+}
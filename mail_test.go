@@ -0,0 +1,97 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"io"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+const mboxWithRepeatedHeaders = `From foo@bar.com
+Received: from a.bar.com by b.bar.com
+Received: from b.bar.com
+  by c.bar.com
+message-id: <abc@bar.com>
+From: Foo S. Ball <foo@bar.com>
+To: Anyone <anyone@bar.com>, Loraine <amiga@bar.com>
+Date: Mon, 2 Jan 2006 15:04:05 +0000
+
+Body text.
+
+From foo@bar.com
+Subject: Next
+
+Next body.
+`
+
+// Given a message with repeated, folded, and oddly-cased headers
+// When I convert it to a net/mail Message
+// Then I expect canonical, unfolded headers and a body confined to the message.
+func TestMailMessage10(t *testing.T) {
+	withOpenMboxStream(t, "TestMailMessage10", mboxWithRepeatedHeaders, func(mr *MboxStream) {
+		msg, err := mr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mm := msg.MailMessage()
+		received := mm.Header["Received"]
+		if len(received) != 2 || received[1] != "from b.bar.com by c.bar.com" {
+			t.Errorf("Received headers wrong: %q", received)
+		}
+		if msg.MessageID() != "abc@bar.com" {
+			t.Errorf("Message-ID wrong: %q", msg.MessageID())
+		}
+		to, err := msg.AddressList("To")
+		if err != nil || len(to) != 2 || to[1].Address != "amiga@bar.com" {
+			t.Error("To addresses wrong: ", to, err)
+		}
+		if d, err := msg.Date(); err != nil || d.Day() != 2 {
+			t.Error("Date wrong: ", d, err)
+		}
+		body, err := io.ReadAll(mm.Body)
+		if err != nil || string(body) != "Body text.\n\n" {
+			t.Errorf("Body wrong: %q, %v", body, err)
+		}
+		if _, err := mr.ReadMessage(); err != nil {
+			t.Error("Next message should follow: ", err)
+		}
+	})
+}
+
+const multipartBody = `--XYZ
+Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: quoted-printable
+
+Caf=E9 au lait
+--XYZ
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Disposition: attachment; filename="=?utf-8?q?invoice=5F1.pdf?="
+Content-Transfer-Encoding: base64
+
+SGVsbG8s
+IHdvcmxk
+--XYZ--
+`
+
+// Given a multipart message with a quoted-printable text part and a base64 attachment
+// When I list its parts
+// Then I expect decoded text and attachment metadata.
+func TestParts10(t *testing.T) {
+	h := mail.Header{"Content-Type": {`multipart/mixed; boundary="XYZ"`}}
+	parts, err := Parts(h, strings.NewReader(multipartBody))
+	if err != nil {
+		t.Fatal("TestParts10: ", err)
+	}
+	if len(parts) != 2 {
+		t.Fatal("Expected 2 parts; got ", len(parts))
+	}
+	if parts[0].Text != "Café au lait" {
+		t.Errorf("Text part wrong: %q", parts[0].Text)
+	}
+	pdf := parts[1]
+	if !pdf.Attachment || pdf.Filename != "invoice_1.pdf" || pdf.Size != 12 || pdf.Text != "" {
+		t.Errorf("Attachment wrong: %+v", pdf)
+	}
+}
@@ -0,0 +1,103 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// A Maildir delivers messages into a Maildir directory, as described at
+// https://cr.yp.to/proto/maildir.html.  Each message is written into tmp,
+// flushed to stable storage, then renamed into new or cur, so readers never
+// see a partial message.
+type Maildir struct {
+	dir string
+}
+
+var maildirCounter int64
+
+// CreateMaildir opens the Maildir at the given path, creating it, along with
+// its tmp, new and cur subdirectories, if necessary.
+func CreateMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &Maildir{dir}, nil
+}
+
+// Dir answers the Maildir's path.
+func (md *Maildir) Dir() string {
+	return md.dir
+}
+
+// Deliver adds a new, unseen message to the Maildir, and answers the path of
+// the file holding it.  The content must be an RFC 5322 message, without a
+// From marker line; Message.WriteEML and View.WriteEML produce suitable
+// content.
+func (md *Maildir) Deliver(content io.Reader) (string, error) {
+	return md.deliver(content, "new", "")
+}
+
+// Store adds a message which a mail client has already seen, carrying the
+// given Maildir flags, such as "S" for seen or "FS" for flagged and seen.
+// Flags are written in ASCII order, as the Maildir specification requires.
+func (md *Maildir) Store(content io.Reader, flags string) (string, error) {
+	f := []byte(flags)
+	for i := 1; i < len(f); i++ {
+		for j := i; j > 0 && f[j] < f[j-1]; j-- {
+			f[j], f[j-1] = f[j-1], f[j]
+		}
+	}
+	return md.deliver(content, "cur", ":2,"+string(f))
+}
+
+func (md *Maildir) deliver(content io.Reader, sub, info string) (path string, err error) {
+	name := uniqueName()
+	tmp := filepath.Join(md.dir, "tmp", name)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	if _, err = io.Copy(f, content); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	path = filepath.Join(md.dir, sub, name+info)
+	if err = os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// uniqueName answers a file name which no other delivery, in this process or
+// any other, will choose: the time, the process ID, a counter, and the host
+// name.
+func uniqueName() string {
+	now := time.Now()
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	n := atomic.AddInt64(&maildirCounter, 1)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), n, host)
+}
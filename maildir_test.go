@@ -0,0 +1,40 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Given a fresh directory
+// When I deliver and store messages into a Maildir there
+// Then I expect them in new and cur, with flags sorted, and nothing in tmp.
+func TestMaildir10(t *testing.T) {
+	md, err := CreateMaildir(filepath.Join(t.TempDir(), "Mail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := md.Deliver(strings.NewReader("Subject: New\n\nHello.\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen, err := md.Store(strings.NewReader("Subject: Seen\n\nHello.\n"), "SF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(filepath.Dir(fresh)) != "new" || filepath.Base(filepath.Dir(seen)) != "cur" {
+		t.Error("Wrong directories: ", fresh, seen)
+	}
+	if !strings.HasSuffix(seen, ":2,FS") {
+		t.Error("Flags should be sorted: ", seen)
+	}
+	if b, err := os.ReadFile(fresh); err != nil || string(b) != "Subject: New\n\nHello.\n" {
+		t.Errorf("Content wrong: %q, %v", b, err)
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(md.Dir(), "tmp")); len(tmp) != 0 {
+		t.Error("tmp should be empty")
+	}
+}
@@ -0,0 +1,80 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import "sort"
+
+// A MappedMbox provides random access to the messages of an mbox file whose
+// contents have been mapped into memory.  Each message is exposed as a View
+// into the mapping, so headers and bodies may be served without further system
+// calls or copies.  On platforms lacking mmap support, the file is instead read
+// into memory in its entirety.
+//
+// Views obtained from a MappedMbox become invalid once it is closed.
+type MappedMbox struct {
+	data  []byte
+	views []View
+	unmap func() error
+}
+
+// OpenMapped maps the named mbox file into memory and indexes its messages.
+// The file must not be truncated while mapped; touching the lost pages crashes
+// the program, unless the goroutine doing so has called debug.SetPanicOnFault,
+// which turns the fault into a panic.  Use CreateMboxStream for readers which
+// aren't files.
+func OpenMapped(path string) (*MappedMbox, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mm := &MappedMbox{data: data, unmap: unmap}
+	s := NewScanner(data)
+	for s.Next() {
+		mm.views = append(mm.views, *s.View())
+	}
+	if s.Err() != nil {
+		mm.Close()
+		return nil, s.Err()
+	}
+	return mm, nil
+}
+
+// Len answers the number of messages in the mailbox.
+func (mm *MappedMbox) Len() int {
+	return len(mm.views)
+}
+
+// Message answers the i'th message of the mailbox, counting from zero.
+func (mm *MappedMbox) Message(i int) *View {
+	return &mm.views[i]
+}
+
+// MessageAt answers the message whose From marker starts at the given byte
+// offset, or nil if no message starts there.
+func (mm *MappedMbox) MessageAt(offset int64) *View {
+	i := sort.Search(len(mm.views), func(i int) bool {
+		return mm.views[i].Offset >= offset
+	})
+	if i < len(mm.views) && mm.views[i].Offset == offset {
+		return &mm.views[i]
+	}
+	return nil
+}
+
+// Bytes answers the entire mapped file.
+func (mm *MappedMbox) Bytes() []byte {
+	return mm.data
+}
+
+// Close releases the mapping.
+func (mm *MappedMbox) Close() error {
+	mm.views = nil
+	mm.data = nil
+	if mm.unmap == nil {
+		return nil
+	}
+	err := mm.unmap()
+	mm.unmap = nil
+	return err
+}
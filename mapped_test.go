@@ -0,0 +1,78 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Given an mbox file on disk
+// When I map it into memory
+// Then I expect random access to each of its messages.
+func TestMapped10(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mboxWith3Messages), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mm, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal("TestMapped10: ", err)
+	}
+	defer mm.Close()
+
+	if mm.Len() != 3 {
+		t.Fatal("Expected 3 messages; got ", mm.Len())
+	}
+	second := mm.Message(1)
+	if string(second.Get("Subject")) != "You're all fired!" {
+		t.Errorf("Wrong subject for second message: %q", second.Get("Subject"))
+	}
+	if mm.MessageAt(second.Offset) != second {
+		t.Error("MessageAt should find the message starting at its offset")
+	}
+	if mm.MessageAt(second.Offset+1) != nil {
+		t.Error("MessageAt should answer nil for offsets inside a message")
+	}
+}
+
+// Given an empty file
+// When I map it into memory
+// Then I expect an empty mailbox.
+func TestMapped20(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mm, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal("TestMapped20: ", err)
+	}
+	defer mm.Close()
+	if mm.Len() != 0 {
+		t.Error("Expected no messages; got ", mm.Len())
+	}
+}
+
+// Given the offset of the third message in a file
+// When I create a stream at that offset
+// Then I expect to read the third message with its offset intact.
+func TestCreateMboxStreamAt10(t *testing.T) {
+	offsets, _ := readSequentially(t, mboxWith3Messages)
+	mr, err := CreateMboxStreamAt(strings.NewReader(mboxWith3Messages), offsets[2])
+	if err != nil {
+		t.Fatal("TestCreateMboxStreamAt10: ", err)
+	}
+	msg, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatal("TestCreateMboxStreamAt10: ", err)
+	}
+	if msg.Headers()["Subject"][0] != "Stella rules!" {
+		t.Error("Wrong message read: ", msg.Headers()["Subject"])
+	}
+	if msg.Offset() != offsets[2] {
+		t.Error("Expected offset ", offsets[2], "; got ", msg.Offset())
+	}
+}
@@ -4,8 +4,10 @@ package mbox
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 )
 
@@ -15,7 +17,13 @@ type MboxStream struct {
 	prefetch       []byte
 	prefetchLength int
 	currentLine    int
+	pos            int64
 	r              *bufio.Reader
+
+	// While reading a message's envelope and headers, raw accumulates
+	// each line exactly as it appeared in the input.
+	raw       []byte
+	recording bool
 }
 
 // The ReadMessage method parses the input for another complete message.  A
@@ -35,8 +43,16 @@ func (m *MboxStream) ReadMessage() (msg *Message, err error) {
 	msg = &Message{
 		mbox:    m,
 		headers: make(map[string][]string, 0),
+		offset:  m.pos,
 	}
 
+	m.raw = nil
+	m.recording = true
+	defer func() {
+		m.recording = false
+		m.raw = nil
+	}()
+
 	msg.sendingAddress, err = m.parseFrom()
 	if err != nil {
 		msg = nil
@@ -55,6 +71,7 @@ func (m *MboxStream) ReadMessage() (msg *Message, err error) {
 		return
 	}
 
+	msg.rawHeader = m.raw
 	return
 }
 
@@ -70,7 +87,7 @@ func (m *MboxStream) errorf(format string, args ...interface{}) error {
 // blank line.  Blank lines are required by the MBOX format conventions to separate
 // MIME headers from message content.
 func (m *MboxStream) parseBlankLine() error {
-	if (len(m.prefetch) > 1) || (m.prefetch[0] != '\n') {
+	if !isBlankLine(m.prefetch) {
 		return m.errorf("Blank line expected")
 	}
 	return m.nextLine()
@@ -88,7 +105,7 @@ func (m *MboxStream) parseFrom() (who string, err error) {
 }
 
 func extractSendingAddress(m *MboxStream) (who string, err error) {
-	if string(m.prefetch[0:5]) != "From " {
+	if !isFromLine(m.prefetch) {
 		return "", io.EOF
 	}
 	if m.prefetchLength < 6 {
@@ -113,7 +130,7 @@ func (m *MboxStream) parseHeaders() (hs map[string][]string, err error) {
 			return nil, err
 		}
 		hs[key] = values
-		if m.prefetch[0] == '\n' {
+		if isBlankLine(m.prefetch) {
 			break
 		}
 	}
@@ -148,7 +165,7 @@ func (m *MboxStream) parseHeader() (key string, values []string, err error) {
 
 	for {
 		// Continuation lines consist of at least one whitespace and at least one regular character.
-		if (m.prefetchLength < 2) || (!isspace(m.prefetch[0])) {
+		if (m.prefetchLength < 2) || (!isspace(m.prefetch[0])) || isBlankLine(m.prefetch) {
 			break
 		}
 		continuation := strings.TrimRight(string(m.prefetch), " \r\n\t\b\v")
@@ -166,13 +183,21 @@ func isspace(b byte) bool {
 	return b < 33
 }
 
+// isFromLine answers true if the line given starts with the "From " marker
+// which separates messages in the mbox file.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, fromMarker)
+}
+
+var fromMarker = []byte("From ")
+
 // CreateMboxStream decorates an io.Reader instance with an mbox parser.
 // It will produce an io.EOF if the file doesn't appear to be an mbox-formatted file.
 // It determines this by verifying the first five characters of the file matches "From " (note the space).
 // Observe, however, that CreateMboxStream() succeeding does not imply that it actually is a correctly formatted mbox file.
 func CreateMboxStream(s io.Reader) (m *MboxStream, err error) {
 	m = &MboxStream{
-		prefetch: make([]byte, 1000),
+		prefetch: make([]byte, 0, 1000),
 		r:        bufio.NewReader(s),
 	}
 
@@ -186,20 +211,52 @@ func CreateMboxStream(s io.Reader) (m *MboxStream, err error) {
 	return
 }
 
+// CreateMboxStreamAt decorates an io.ReaderAt with an mbox parser, starting
+// at the given byte offset.  The offset must refer to the From marker of a
+// message, as reported by Message.Offset() or View.Offset.  Offsets of messages
+// read from the resulting stream remain relative to the start of r, so they may
+// be used for further random access.
+func CreateMboxStreamAt(r io.ReaderAt, offset int64) (m *MboxStream, err error) {
+	m, err = CreateMboxStream(io.NewSectionReader(r, offset, math.MaxInt64-offset))
+	if m != nil {
+		m.pos = offset
+	}
+	return
+}
+
 // nextLine retrieves the next logical line from the mbox file.  The caller
 // should be concerned with one of three cases:
 //
 // - A successful read yields no error.
 // - Attempting to read past the end of the input stream yields io.EOF.
 // - All other errors are reported as necessary.
+//
+// Lines longer than the underlying bufio.Reader's buffer are reassembled, so
+// callers always see a complete line.  A final line lacking its terminator is
+// still returned, so that no input is lost.
 func (m *MboxStream) nextLine() error {
-	slice, err := m.r.ReadSlice('\n')
-	if err != nil {
-		return err
+	consumed := len(m.prefetch)
+	if m.recording {
+		m.raw = append(m.raw, m.prefetch...)
+	}
+	line := m.prefetch[:0]
+	for {
+		slice, err := m.r.ReadSlice('\n')
+		line = append(line, slice...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		break
 	}
-	m.prefetch = m.prefetch[0:len(slice)]
-	copy(m.prefetch, slice)
+	m.prefetch = line
 	m.prefetchLength = len(m.prefetch)
+	m.pos += int64(consumed)
 	m.currentLine++
 	return nil
 }
@@ -465,6 +465,44 @@ func TestOkMboxFile80(t *testing.T) {
 	})
 }
 
+// Given mbox files with unusual spacing, CRLF line endings, and no final
+// line terminator
+// When I write each message back out
+// Then I expect to reproduce the input byte for byte.
+func TestRoundTrip10(t *testing.T) {
+	sources := []string{
+		mboxWith3Messages,
+		mboxWithMessage3Headers,
+		"From foo@bar.com\r\nSubject:   spaced  \r\n\r\nCRLF body\r\n",
+		"From foo@bar.com\nSubject: Hello\n\nNo final newline",
+	}
+	for _, source := range sources {
+		withOpenMboxStream(t, "TestRoundTrip10", source, func(mr *MboxStream) {
+			var out strings.Builder
+			for {
+				msg, err := mr.ReadMessage()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Error("TestRoundTrip10: ", err)
+					return
+				}
+				if !strings.HasPrefix(source[msg.Offset():], string(msg.RawHeader())) {
+					t.Errorf("Raw header %q not found at offset %d", msg.RawHeader(), msg.Offset())
+				}
+				if _, err := msg.WriteTo(&out); err != nil {
+					t.Error("TestRoundTrip10: ", err)
+					return
+				}
+			}
+			if out.String() != source {
+				t.Errorf("Expected %q; got %q", source, out.String())
+			}
+		})
+	}
+}
+
 /* *** Examples *** */
 
 func ExampleMboxStream() {
@@ -0,0 +1,300 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/gob"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// A SortKey selects how Merge orders messages.
+type SortKey int
+
+const (
+	// SortByDate orders messages by their Date headers, falling back on
+	// their envelope dates.
+	SortByDate SortKey = iota
+
+	// SortByEnvelope orders messages by the dates on their From marker
+	// lines, falling back on their Date headers.
+	SortByEnvelope
+)
+
+// MergeOptions tunes the behavior of Merge.  The zero value sorts by Date
+// header, writes Mboxo, keeps duplicates, and buffers up to 64 MiB of messages
+// in memory.
+type MergeOptions struct {
+	// SortBy selects the ordering of the merged mailbox.  Messages with
+	// equal dates, or no date at all, keep their input order; undated
+	// messages sort first.
+	SortBy SortKey
+
+	// Dialect selects the dialect of the merged mailbox.  The dialect of
+	// each input is detected and its escaping reversed before the message
+	// is written out again.
+	Dialect Dialect
+
+	// Dedupe, if true, drops messages found to duplicate an earlier one,
+	// in input order, according to DedupeBy.
+	Dedupe   bool
+	DedupeBy DedupeKey
+
+	// MaxMemory bounds the bytes of message content held in memory.  Once
+	// exceeded, messages are sorted and spilled into temporary files in
+	// TempDir, which are merged at the end.
+	MaxMemory int64
+	TempDir   string
+}
+
+// A mergeRecord carries one message through sorting.  Key holds the date by
+// which it sorts, if Dated is true.
+type mergeRecord struct {
+	Key     time.Time
+	Dated   bool
+	Seq     int64
+	Sender  string
+	Date    time.Time
+	Content []byte
+}
+
+// Merge combines the named mbox files into a single mailbox written to w,
+// sorted by date.  Memory use is bounded by opts.MaxMemory; larger inputs are
+// sorted externally.
+func Merge(w io.Writer, inputs []string, opts *MergeOptions) error {
+	var o MergeOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxMemory <= 0 {
+		o.MaxMemory = 64 << 20
+	}
+
+	m := &merger{opts: o}
+	if o.Dedupe {
+		m.dedupe = NewDeduper(o.DedupeBy)
+	}
+	defer m.cleanup()
+
+	for _, name := range inputs {
+		if err := m.read(name); err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	out := NewWriter(bw, o.Dialect)
+	emit := func(r *mergeRecord) error {
+		return out.WriteMessage(Envelope{Sender: r.Sender, Date: r.Date}, bytes.NewReader(r.Content))
+	}
+
+	var err error
+	if len(m.runs) == 0 {
+		m.sortPending()
+		for _, r := range m.pending {
+			if err = emit(r); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err = m.spill(); err != nil {
+			return err
+		}
+		if err = m.mergeRuns(emit); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type merger struct {
+	opts    MergeOptions
+	dedupe  *Deduper
+	seq     int64
+	pending []*mergeRecord
+	size    int64
+	runs    []*os.File
+}
+
+// read adds every message of the named mailbox to the merge.
+func (m *merger) read(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := DetectDialectAt(f)
+	if err != nil {
+		return err
+	}
+	s, err := CreateMboxStream(f)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var raw bytes.Buffer
+		if _, err = msg.WriteTo(&raw); err != nil {
+			return err
+		}
+		body := raw.Bytes()[len(msg.RawHeader()):]
+		if m.dedupe != nil {
+			if _, dup := m.dedupe.Check(msg, body, d); dup {
+				continue
+			}
+		}
+
+		var content bytes.Buffer
+		if err = writeEML(&content, msg.headerBlock(), bytes.NewReader(body), d); err != nil {
+			return err
+		}
+		r := &mergeRecord{Seq: m.seq, Content: content.Bytes()}
+		m.seq++
+
+		env, envErr := msg.Envelope()
+		hdrDate, hdrErr := msg.Date()
+		r.Sender, r.Date = env.Sender, env.Date
+		if envErr != nil {
+			r.Date = EnvelopeOf(msg.MailHeader()).Date
+		}
+		key := hdrDate
+		if hdrErr != nil || (m.opts.SortBy == SortByEnvelope && envErr == nil) {
+			key = r.Date
+		}
+		r.Key, r.Dated = key, !key.IsZero()
+
+		m.pending = append(m.pending, r)
+		m.size += int64(len(r.Content))
+		if m.size > m.opts.MaxMemory {
+			if err = m.spill(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *merger) sortPending() {
+	sort.Slice(m.pending, func(i, j int) bool {
+		return m.pending[i].less(m.pending[j])
+	})
+}
+
+func (r *mergeRecord) less(s *mergeRecord) bool {
+	if r.Dated != s.Dated {
+		return !r.Dated
+	}
+	if r.Dated && !r.Key.Equal(s.Key) {
+		return r.Key.Before(s.Key)
+	}
+	return r.Seq < s.Seq
+}
+
+// spill sorts the pending messages and writes them to a temporary file.
+func (m *merger) spill() error {
+	if len(m.pending) == 0 {
+		return nil
+	}
+	m.sortPending()
+
+	f, err := os.CreateTemp(m.opts.TempDir, "mbox-merge-")
+	if err != nil {
+		return err
+	}
+	m.runs = append(m.runs, f)
+
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, r := range m.pending {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	m.pending, m.size = nil, 0
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// A runReader yields the records of one sorted run in turn.
+type runReader struct {
+	dec  *gob.Decoder
+	head *mergeRecord
+}
+
+func (rr *runReader) next() error {
+	rr.head = &mergeRecord{}
+	err := rr.dec.Decode(rr.head)
+	if err != nil {
+		rr.head = nil
+	}
+	return err
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].head.less(h[j].head) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// mergeRuns performs a k-way merge of the spilled runs.
+func (m *merger) mergeRuns(emit func(*mergeRecord) error) error {
+	var h runHeap
+	for _, f := range m.runs {
+		rr := &runReader{dec: gob.NewDecoder(bufio.NewReader(f))}
+		if err := rr.next(); err == nil {
+			h = append(h, rr)
+		} else if err != io.EOF {
+			return err
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		rr := h[0]
+		if err := emit(rr.head); err != nil {
+			return err
+		}
+		switch err := rr.next(); err {
+		case nil:
+			heap.Fix(&h, 0)
+		case io.EOF:
+			heap.Pop(&h)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanup removes any temporary files.
+func (m *merger) cleanup() {
+	for _, f := range m.runs {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,130 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const mboxMergeA = `From a@bar.com Mon Jan  2 10:00:00 2006
+Message-ID: <1@bar.com>
+Subject: First
+Date: Mon, 2 Jan 2006 10:00:00 +0000
+
+>From the first mailbox.
+
+From a@bar.com Wed Jan  4 10:00:00 2006
+Message-ID: <3@bar.com>
+Subject: Third
+Date: Wed, 4 Jan 2006 10:00:00 +0000
+
+Third.
+`
+
+const mboxMergeB = `From b@bar.com Tue Jan  3 10:00:00 2006
+Message-ID: <2@bar.com>
+Subject: Second
+Date: Tue, 3 Jan 2006 10:00:00 +0000
+
+Second.
+
+From b@bar.com Wed Jan  4 10:00:00 2006
+Message-ID: <3@bar.com>
+Subject: Third
+Date: Wed, 4 Jan 2006 10:00:00 +0000
+
+Third.
+`
+
+// mergeSubjects merges the two test mailboxes and answers the subjects of the
+// result, along with the merged mailbox itself.
+func mergeSubjects(t *testing.T, opts *MergeOptions) (string, string) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, []byte(mboxMergeA), 0600)
+	os.WriteFile(b, []byte(mboxMergeB), 0600)
+
+	var out bytes.Buffer
+	if err := Merge(&out, []string{a, b}, opts); err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	s := NewScanner(out.Bytes())
+	for s.Next() {
+		subjects = append(subjects, string(s.View().Get("Subject")))
+	}
+	return strings.Join(subjects, ","), out.String()
+}
+
+// Given two mailboxes with interleaved dates
+// When I merge them
+// Then I expect a single mailbox in date order, in the chosen dialect.
+func TestMerge10(t *testing.T) {
+	subjects, out := mergeSubjects(t, &MergeOptions{Dialect: Mboxrd})
+	if subjects != "First,Second,Third,Third" {
+		t.Error("Unexpected order: ", subjects)
+	}
+	if !strings.Contains(out, "\n>From the first mailbox.\n") {
+		t.Error("Escaping should be carried across dialects")
+	}
+}
+
+// Given two mailboxes sharing a message
+// When I merge them with deduplication, spilling to disk
+// Then I expect the duplicate dropped and the order preserved.
+func TestMerge20(t *testing.T) {
+	subjects, _ := mergeSubjects(t, &MergeOptions{Dedupe: true, MaxMemory: 1, TempDir: t.TempDir()})
+	if subjects != "First,Second,Third" {
+		t.Error("Unexpected order: ", subjects)
+	}
+}
+
+const mboxMergeOutliers = `From c@bar.com Mon Jan  2 10:00:00 2006
+Subject: Far future
+Date: Mon, 1 Jan 2300 10:00:00 +0000
+
+Late.
+
+From c@bar.com
+Subject: Undated
+
+No date at all.
+
+From c@bar.com
+Subject: Epoch
+Date: Thu, 1 Jan 1970 00:00:00 +0000
+
+Early.
+`
+
+// Given a mailbox with messages dated at the epoch, far in the future, and
+// not at all
+// When I merge it with the others, in memory and spilling to disk
+// Then I expect undated messages first and the rest in date order.
+func TestMerge30(t *testing.T) {
+	dir := t.TempDir()
+	var inputs []string
+	for i, mailbox := range []string{mboxMergeA, mboxMergeOutliers} {
+		name := filepath.Join(dir, string(rune('a'+i)))
+		os.WriteFile(name, []byte(mailbox), 0600)
+		inputs = append(inputs, name)
+	}
+	for _, opts := range []*MergeOptions{nil, {MaxMemory: 1, TempDir: t.TempDir()}} {
+		var out bytes.Buffer
+		if err := Merge(&out, inputs, opts); err != nil {
+			t.Fatal(err)
+		}
+		var subjects []string
+		s := NewScanner(out.Bytes())
+		for s.Next() {
+			subjects = append(subjects, string(s.View().Get("Subject")))
+		}
+		if got := strings.Join(subjects, ","); got != "Undated,Epoch,First,Third,Far future" {
+			t.Error("Unexpected order: ", got)
+		}
+	}
+}
@@ -2,13 +2,19 @@
 
 package mbox
 
-import "io"
+import (
+	"io"
+	"net/mail"
+)
 
 // A Message represents a single message in the file.
 type Message struct {
 	mbox           *MboxStream
 	headers        map[string][]string
 	sendingAddress string
+	offset         int64
+	rawHeader      []byte
+	mailHeader     mail.Header
 }
 
 // A bodyReader implements an io.Reader, confined to the current message to
@@ -28,6 +34,12 @@ func (m *Message) Sender() string {
 	return m.sendingAddress
 }
 
+// Offset() tells where the message starts, measured in bytes from the start of
+// the input stream.  The offset refers to the first byte of the From marker.
+func (m *Message) Offset() int64 {
+	return m.offset
+}
+
 // The Headers method provides raw access to the headers of a message.
 // Applications identify headers by a name string.  Each header contains one or
 // more value strings, each string corresponding to a line in the MIME header
@@ -58,6 +70,33 @@ func (m *Message) Headers() map[string][]string {
 	return m.headers
 }
 
+// RawHeader() provides the message's From marker line, its header block, and
+// the blank line which follows, exactly as they appeared in the input.
+// Together with the bytes produced by BodyReader(), this reproduces the
+// message byte for byte.
+func (m *Message) RawHeader() []byte {
+	return m.rawHeader
+}
+
+// headerBlock answers the raw header block, without the From marker line.
+func (m *Message) headerBlock() []byte {
+	return m.rawHeader[lineEnd(m.rawHeader, 0):]
+}
+
+// WriteTo() writes the entire message, exactly as it appeared in the input, to
+// the given io.Writer.  Since it reads the body to do so, it must be called
+// before any of the body has been read.
+func (m *Message) WriteTo(w io.Writer) (n int64, err error) {
+	k, err := w.Write(m.rawHeader)
+	n = int64(k)
+	if err != nil {
+		return
+	}
+	k64, err := io.Copy(w, m.BodyReader())
+	n += k64
+	return
+}
+
 // BodyReader() provides an io.Reader compatible object that will read the body
 // of the message.  It will return io.EOF if you attempt to read beyond the end
 // of the message.
@@ -75,7 +114,7 @@ func (r *bodyReader) Read(bs []byte) (n int, err error) {
 		return 0, r.srcErr
 	}
 
-	if (len(r.mbox.prefetch) > 5) && (string(r.mbox.prefetch[0:5]) == "From ") {
+	if (len(r.mbox.prefetch) > 5) && isFromLine(r.mbox.prefetch) {
 		return 0, io.EOF
 	}
 
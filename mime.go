@@ -0,0 +1,151 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Part describes one leaf of a message's MIME structure: a body part which
+// isn't itself multipart.
+type Part struct {
+	// ContentType holds the lower-cased media type, such as "text/plain".
+	ContentType string
+
+	// Params holds the parameters of the Content-Type header.
+	Params map[string]string
+
+	// Filename names the part, if it carries a name through either its
+	// Content-Disposition or Content-Type headers.
+	Filename string
+
+	// Attachment answers true if the part is meant to be saved rather than
+	// displayed inline: it has an attachment disposition, or a file name.
+	Attachment bool
+
+	// Size counts the bytes of the part after transfer decoding.
+	Size int
+
+	// Text holds the decoded content of textual parts which aren't
+	// attachments.  Latin-1 and ASCII content is converted to UTF-8;
+	// other character sets are left as they are.
+	Text string
+}
+
+// A headerGetter is satisfied by both mail.Header and textproto.MIMEHeader.
+type headerGetter interface {
+	Get(key string) string
+}
+
+// Parts reads a message body and answers its MIME parts, in order, with
+// multipart containers flattened away.  Bodies of messages lacking MIME
+// headers are treated as a single text/plain part.  If the structure proves
+// malformed, the parts found so far are returned along with the error.
+func Parts(h mail.Header, body io.Reader) ([]Part, error) {
+	var parts []Part
+	err := walkParts(h, body, &parts)
+	return parts, err
+}
+
+// Parts reads the message's body and answers its MIME parts.  Like
+// BodyReader(), this consumes the body.
+func (m *Message) Parts() ([]Part, error) {
+	return Parts(m.MailHeader(), m.BodyReader())
+}
+
+// Text concatenates the decoded text of all the given parts, separating parts
+// with newlines.
+func Text(parts []Part) string {
+	var texts []string
+	for _, p := range parts {
+		if p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+var wordDecoder mime.WordDecoder
+
+// DecodeHeader decodes any RFC 2047 encoded words in a header value, such as
+// "=?utf-8?q?caf=C3=A9?=".  Values which cannot be decoded are returned as
+// they are.
+func DecodeHeader(v string) string {
+	if d, err := wordDecoder.DecodeHeader(v); err == nil {
+		return d
+	}
+	return v
+}
+
+func walkParts(h headerGetter, body io.Reader, parts *[]Part) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := walkParts(p.Header, p, parts); err != nil {
+				return err
+			}
+		}
+	}
+
+	part := Part{ContentType: mediaType, Params: params}
+	if disposition, dparams, err := mime.ParseMediaType(h.Get("Content-Disposition")); err == nil {
+		part.Attachment = disposition == "attachment"
+		part.Filename = dparams["filename"]
+	}
+	if part.Filename == "" {
+		part.Filename = params["name"]
+	}
+	if name, err := wordDecoder.DecodeHeader(part.Filename); err == nil {
+		part.Filename = name
+	}
+	part.Attachment = part.Attachment || part.Filename != ""
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	part.Size = len(data)
+	if strings.HasPrefix(mediaType, "text/") && !part.Attachment {
+		part.Text = decodeCharset(data, params["charset"])
+	}
+	*parts = append(*parts, part)
+	return err
+}
+
+// decodeCharset converts text in the named character set to UTF-8 where the
+// standard library makes that possible.
+func decodeCharset(data []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "us-ascii":
+		if !utf8.Valid(data) {
+			rs := make([]rune, len(data))
+			for i, b := range data {
+				rs[i] = rune(b)
+			}
+			return string(rs)
+		}
+	}
+	return string(data)
+}
@@ -0,0 +1,14 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build !linux
+
+package mbox
+
+import "os"
+
+// mapFile reads the named file into memory, standing in for mmap on platforms
+// where this package doesn't support it.
+func mapFile(path string) (data []byte, unmap func() error, err error) {
+	data, err = os.ReadFile(path)
+	return data, nil, err
+}
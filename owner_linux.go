@@ -0,0 +1,26 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build linux
+
+package mbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner gives f the owner and group recorded in fi, so a mailbox
+// rewritten by root, such as a delivery agent or mail server, still belongs
+// to its user.  Ordinary users may not give files away; for them, a refusal
+// is ignored and the file stays theirs.
+func copyOwner(f *os.File, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := f.Chown(int(st.Uid), int(st.Gid))
+	if os.IsPermission(err) && os.Geteuid() != 0 {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build linux
+
+package mbox
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Given a mailbox belonging to another user
+// When root edits it
+// Then I expect the rewritten mailbox to still belong to that user.
+func TestCopyOwner10(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root may give files away")
+	}
+	withEditor(t, mboxWithMessageIDs, func(path string, e *Editor) {
+		if err := os.Chown(path, 1234, 5678); err != nil {
+			t.Fatal(err)
+		}
+		e.Delete(0)
+		if err := e.Commit(); err != nil {
+			t.Fatal("TestCopyOwner10: ", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := fi.Sys().(*syscall.Stat_t); st.Uid != 1234 || st.Gid != 5678 {
+			t.Errorf("Owner wrong after commit: %d:%d", st.Uid, st.Gid)
+		}
+	})
+}
@@ -0,0 +1,13 @@
+// vim: ts=8 noexpandtab ai
+
+//go:build !linux
+
+package mbox
+
+import "os"
+
+// copyOwner does nothing on platforms where this package doesn't track file
+// ownership.
+func copyOwner(f *os.File, fi os.FileInfo) error {
+	return nil
+}
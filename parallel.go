@@ -0,0 +1,308 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ParallelOptions tunes the behavior of ReadParallel and StreamParallel.  The
+// zero value selects reasonable defaults for each field.
+type ParallelOptions struct {
+	// Workers sets how many goroutines parse chunks concurrently.  It
+	// defaults to runtime.NumCPU().
+	Workers int
+
+	// ChunkSize sets the approximate number of bytes handed to each worker.
+	// Chunks always begin on a From marker, so actual chunks may be larger.
+	// It defaults to 4 MiB.
+	ChunkSize int64
+
+	// MaxChunks bounds the number of chunks held in memory at any one time,
+	// whether being parsed or waiting for delivery.  It defaults to twice
+	// the number of workers.
+	MaxChunks int
+
+	// Ordered, if true, delivers messages in the order they appear in the
+	// file.  Otherwise, messages are delivered as soon as their chunk has
+	// been parsed.
+	Ordered bool
+}
+
+// A ParsedMessage is a fully buffered message produced by ReadParallel.
+// Unlike a Message, its body has already been read into memory, so it remains
+// valid after subsequent messages have been delivered.
+type ParsedMessage struct {
+	Offset  int64
+	Sender  string
+	Headers map[string][]string
+	Body    []byte
+}
+
+type chunk struct {
+	seq        int
+	start, end int64
+	err        error
+}
+
+type chunkResult struct {
+	seq  int
+	msgs []*ParsedMessage
+	err  error
+}
+
+// withDefaults returns a copy of the options with unset fields filled in.
+func (o *ParallelOptions) withDefaults() ParallelOptions {
+	var p ParallelOptions
+	if o != nil {
+		p = *o
+	}
+	if p.Workers < 1 {
+		p.Workers = runtime.NumCPU()
+	}
+	if p.ChunkSize < 1 {
+		p.ChunkSize = 4 << 20
+	}
+	if p.MaxChunks < 1 {
+		p.MaxChunks = 2 * p.Workers
+	}
+	return p
+}
+
+// ReadParallel parses the size bytes of mbox data held in r using several
+// goroutines at once.  The input is split into chunks at verified From
+// markers, each chunk is parsed independently, and the resulting messages are
+// handed to fn.  ReadParallel never calls fn concurrently, so fn needs no
+// locking of its own.
+//
+// If fn returns an error, ReadParallel stops scheduling further work and
+// returns that error.  Likewise, the first parse error encountered stops the
+// read.  As with CreateMboxStream, input which doesn't start with a From
+// marker yields io.EOF.
+func ReadParallel(r io.ReaderAt, size int64, opts *ParallelOptions, fn func(*ParsedMessage) error) error {
+	o := opts.withDefaults()
+	jobs := make(chan chunk)
+	results := make(chan chunkResult)
+	done := make(chan struct{})
+	slots := make(chan struct{}, o.MaxChunks)
+
+	go func() {
+		defer close(jobs)
+		seq := 0
+		for start := int64(0); start < size; seq++ {
+			end, err := nextBoundary(r, size, start+o.ChunkSize)
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- chunk{seq: seq, start: start, end: end, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			start = end
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				res := parseChunk(r, c)
+				select {
+				case results <- res:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	deliver := func(res chunkResult) error {
+		<-slots
+		if res.err != nil {
+			return res.err
+		}
+		for _, msg := range res.msgs {
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	pending := make(map[int]chunkResult)
+	next := 0
+	for res := range results {
+		if err != nil {
+			continue
+		}
+		if !o.Ordered {
+			err = deliver(res)
+		} else {
+			pending[res.seq] = res
+			for err == nil {
+				p, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				err = deliver(p)
+			}
+		}
+		if err != nil {
+			close(done)
+		}
+	}
+	return err
+}
+
+// StreamParallel behaves as ReadParallel, except that messages are delivered
+// over a channel.  Once all messages have been sent, the message channel is
+// closed and the outcome of the read is sent on the error channel.  Callers
+// must drain the message channel completely.
+func StreamParallel(r io.ReaderAt, size int64, opts *ParallelOptions) (<-chan *ParsedMessage, <-chan error) {
+	msgs := make(chan *ParsedMessage)
+	errs := make(chan error, 1)
+	go func() {
+		err := ReadParallel(r, size, opts, func(msg *ParsedMessage) error {
+			msgs <- msg
+			return nil
+		})
+		close(msgs)
+		errs <- err
+	}()
+	return msgs, errs
+}
+
+// parseChunk reads in a chunk of the input and parses each of its messages in
+// turn.  Offsets are reported relative to the start of the whole input.
+func parseChunk(r io.ReaderAt, c chunk) (res chunkResult) {
+	res.seq = c.seq
+	if c.err != nil {
+		res.err = c.err
+		return
+	}
+
+	buf := make([]byte, c.end-c.start)
+	n, err := r.ReadAt(buf, c.start)
+	if n < len(buf) {
+		res.err = err
+		return
+	}
+
+	m, err := CreateMboxStream(bytes.NewReader(buf))
+	if err != nil {
+		res.err = err
+		return
+	}
+	m.pos = c.start
+
+	for {
+		msg, err := m.ReadMessage()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			res.err = fmt.Errorf("chunk at offset %d: %v", c.start, err)
+			return
+		}
+		body, err := io.ReadAll(msg.BodyReader())
+		if err != nil {
+			res.err = err
+			return
+		}
+		res.msgs = append(res.msgs, &ParsedMessage{
+			Offset:  msg.Offset(),
+			Sender:  msg.Sender(),
+			Headers: msg.Headers(),
+			Body:    body,
+		})
+	}
+}
+
+// nextBoundary locates the first verified From marker at or after the offset
+// given.  If none exists, the size of the input is returned instead.
+func nextBoundary(r io.ReaderAt, size, from int64) (int64, error) {
+	const window = 64 * 1024
+	sep := []byte("\nFrom ")
+
+	if from >= size {
+		return size, nil
+	}
+
+	buf := make([]byte, window)
+	for start := from - 1; start < size; start += int64(window - len(sep) + 1) {
+		n := int64(window)
+		if size-start < n {
+			n = size - start
+		}
+		if _, err := r.ReadAt(buf[:n], start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := 0; ; {
+			j := bytes.Index(buf[i:n], sep)
+			if j < 0 {
+				break
+			}
+			candidate := start + int64(i+j) + 1
+			ok, err := verifySeparator(r, size, candidate)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return candidate, nil
+			}
+			i += j + 1
+		}
+		if start+n >= size {
+			break
+		}
+	}
+	return size, nil
+}
+
+// verifySeparator answers true if the line at the given offset looks like a
+// genuine message separator: a From marker naming a sender, followed by a line
+// which looks like a message header.
+func verifySeparator(r io.ReaderAt, size, at int64) (bool, error) {
+	buf := make([]byte, 2048)
+	if size-at < int64(len(buf)) {
+		buf = buf[:size-at]
+	}
+	if _, err := r.ReadAt(buf, at); err != nil && err != io.EOF {
+		return false, err
+	}
+
+	eol := bytes.IndexByte(buf, '\n')
+	if eol < 0 || len(bytes.TrimSpace(buf[len(fromMarker):eol])) == 0 {
+		return false, nil
+	}
+	header := buf[eol+1:]
+	if eol2 := bytes.IndexByte(header, '\n'); eol2 >= 0 {
+		header = header[:eol2]
+	} else {
+		return false, nil
+	}
+	k := bytes.IndexByte(header, ':')
+	if k < 1 || bytes.ContainsAny(header[:k], " \t") {
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,120 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// bigMbox synthesizes a mailbox with n messages of varying sizes.
+func bigMbox(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "From user%d@bar.com\nSubject: Message %d\n\n", i, i)
+		for j := 0; j < i%7; j++ {
+			fmt.Fprintf(&sb, "Line %d of message %d\n", j, i)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// readSequentially collects the offsets and subjects of every message using a
+// plain MboxStream.
+func readSequentially(t *testing.T, s string) (offsets []int64, subjects []string) {
+	mr, err := CreateMboxStream(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, err := mr.ReadMessage()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, msg.Offset())
+		subjects = append(subjects, msg.Headers()["Subject"][0])
+		io.Copy(io.Discard, msg.BodyReader())
+	}
+}
+
+// Given a large mbox file
+// When I read it in parallel, in order, using small chunks
+// Then I expect the same messages as reading it sequentially.
+func TestReadParallel10(t *testing.T) {
+	s := bigMbox(200)
+	offsets, subjects := readSequentially(t, s)
+
+	i := 0
+	opts := &ParallelOptions{Workers: 4, ChunkSize: 100, Ordered: true}
+	err := ReadParallel(strings.NewReader(s), int64(len(s)), opts, func(msg *ParsedMessage) error {
+		if msg.Offset != offsets[i] {
+			t.Errorf("Message %d: expected offset %d, got %d", i, offsets[i], msg.Offset)
+		}
+		if msg.Headers["Subject"][0] != subjects[i] {
+			t.Errorf("Message %d: expected subject %q, got %q", i, subjects[i], msg.Headers["Subject"][0])
+		}
+		if !strings.HasPrefix(s[msg.Offset:], "From "+msg.Sender) {
+			t.Errorf("Message %d: offset doesn't point at its From marker", i)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Error("TestReadParallel10: ", err)
+		return
+	}
+	if i != len(offsets) {
+		t.Error("Expected ", len(offsets), " messages; got ", i)
+	}
+}
+
+// Given a large mbox file
+// When I read it in parallel without ordering
+// Then I expect to see every message exactly once.
+func TestReadParallel20(t *testing.T) {
+	s := bigMbox(150)
+	offsets, _ := readSequentially(t, s)
+
+	seen := make(map[int64]int)
+	msgs, errs := StreamParallel(strings.NewReader(s), int64(len(s)), &ParallelOptions{ChunkSize: 64, MaxChunks: 3})
+	for msg := range msgs {
+		seen[msg.Offset]++
+	}
+	if err := <-errs; err != nil {
+		t.Error("TestReadParallel20: ", err)
+		return
+	}
+	for _, o := range offsets {
+		if seen[o] != 1 {
+			t.Error("Message at offset ", o, " seen ", seen[o], " times")
+		}
+	}
+}
+
+// Given a large mbox file
+// When my callback fails part-way through
+// Then I expect ReadParallel to stop and return my error.
+func TestReadParallel30(t *testing.T) {
+	s := bigMbox(100)
+	stop := fmt.Errorf("stop")
+	n := 0
+	err := ReadParallel(strings.NewReader(s), int64(len(s)), &ParallelOptions{ChunkSize: 50, Ordered: true}, func(*ParsedMessage) error {
+		n++
+		if n == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Error("Expected callback error; got ", err)
+	}
+	if n != 10 {
+		t.Error("Expected delivery to stop after 10 messages; got ", n)
+	}
+}
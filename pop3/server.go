@@ -0,0 +1,441 @@
+// vim: ts=8 noexpandtab ai
+
+// Package pop3 serves mbox files over POP3, as described by RFC 1939.
+//
+// Each user has a single mailbox.  It stays locked, as mbox.OpenEditor locks
+// it, from a successful PASS until the session ends, so no other POP3 session
+// or delivery agent may change it meanwhile.  Messages marked with DELE are
+// removed by rewriting the mailbox when the client sends QUIT; a session which
+// ends any other way leaves the mailbox untouched.  A user whose mailbox file
+// doesn't exist yet sees an empty maildrop.
+package pop3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A Server serves users' mailboxes over POP3.
+type Server struct {
+	// Authenticate decides whether to accept a user's password.  If nil,
+	// every login is refused.
+	Authenticate func(user, password string) bool
+
+	// Mailbox answers the path of the user's mbox file.
+	Mailbox func(user string) string
+
+	// LockTimeout bounds how long to wait for a mailbox which another
+	// process has locked.  If zero, five seconds are allowed.
+	LockTimeout time.Duration
+
+	// ErrorLog receives errors which can't be reported to a client.  If
+	// nil, they're logged through the log package.
+	ErrorLog *log.Logger
+}
+
+// idleTimeout bounds how long a client may remain silent.  RFC 1939 requires
+// at least ten minutes.
+const idleTimeout = 10 * time.Minute
+
+// refreshInterval sets how often a session renews its mailbox's dotlock.  A
+// client may idle for up to idleTimeout, longer than the eight minutes after
+// which procmail and others presume a dotlock abandoned.
+const refreshInterval = time.Minute
+
+// maxCommandLength bounds a command line.  RFC 2449 permits 255 octets.
+const maxCommandLength = 512
+
+// Serve accepts connections from the listener, serving each in its own
+// goroutine, until accepting fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// A session holds the state of one client connection.
+type session struct {
+	srv    *Server
+	w      *bufio.Writer
+	user   string
+	authed bool
+	editor *mbox.Editor // nil if the user has no mailbox yet
+	stop   func()       // stops refreshing the editor's dotlock
+	sizes  []int
+	uidls  []string
+}
+
+// ServeConn conducts a single POP3 session over the connection, closing it
+// when the client quits or disconnects.
+func (s *Server) ServeConn(c net.Conn) {
+	defer c.Close()
+	ss := &session{srv: s, w: bufio.NewWriter(c)}
+	defer ss.release()
+
+	r := bufio.NewReaderSize(c, maxCommandLength)
+	ss.ok("mbox POP3 server ready")
+	for {
+		if err := ss.w.Flush(); err != nil {
+			return
+		}
+		c.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			ss.err("Command too long")
+			ss.w.Flush()
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				s.logf("pop3: %s: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			ss.err("Empty command")
+			continue
+		}
+		if ss.dispatch(strings.ToUpper(fields[0]), fields[1:], string(line)) {
+			ss.w.Flush()
+			return
+		}
+	}
+}
+
+func (ss *session) ok(format string, args ...interface{}) {
+	ss.w.WriteString("+OK ")
+	fmt.Fprintf(ss.w, format, args...)
+	ss.w.WriteString("\r\n")
+}
+
+func (ss *session) err(format string, args ...interface{}) {
+	ss.w.WriteString("-ERR ")
+	fmt.Fprintf(ss.w, format, args...)
+	ss.w.WriteString("\r\n")
+}
+
+// dispatch carries out a single command, and answers true if the session
+// should end.
+func (ss *session) dispatch(cmd string, args []string, line string) bool {
+	switch cmd {
+	case "QUIT":
+		ss.quit()
+		return true
+	case "CAPA":
+		ss.ok("Capability list follows")
+		ss.w.WriteString("USER\r\nTOP\r\nUIDL\r\nRESP-CODES\r\n.\r\n")
+		return false
+	}
+
+	if !ss.authed {
+		switch cmd {
+		case "USER":
+			if len(args) != 1 {
+				ss.err("USER needs a name")
+				return false
+			}
+			ss.user = args[0]
+			ss.ok("Send your password")
+		case "PASS":
+			// Passwords may contain spaces, so take the rest of the
+			// line verbatim.
+			password := strings.TrimRight(line, "\r\n")
+			password = strings.TrimPrefix(password[len("PASS"):], " ")
+			ss.pass(password)
+		default:
+			ss.err("Please log in with USER and PASS first")
+		}
+		return false
+	}
+
+	switch cmd {
+	case "STAT":
+		n, size := 0, 0
+		for i := 0; i < ss.len(); i++ {
+			if !ss.editor.Deleted(i) {
+				n++
+				size += ss.size(i)
+			}
+		}
+		ss.ok("%d %d", n, size)
+	case "LIST", "UIDL":
+		info := func(i int) string {
+			if cmd == "LIST" {
+				return strconv.Itoa(ss.size(i))
+			}
+			return ss.uidls[i]
+		}
+		if len(args) > 0 {
+			if i, ok := ss.message(args[0]); ok {
+				ss.ok("%d %s", i+1, info(i))
+			}
+			return false
+		}
+		ss.ok("Listing follows")
+		for i := 0; i < ss.len(); i++ {
+			if !ss.editor.Deleted(i) {
+				fmt.Fprintf(ss.w, "%d %s\r\n", i+1, info(i))
+			}
+		}
+		ss.w.WriteString(".\r\n")
+	case "RETR":
+		if len(args) != 1 {
+			ss.err("RETR needs a message number")
+			return false
+		}
+		if i, ok := ss.message(args[0]); ok {
+			ss.ok("%d octets", ss.size(i))
+			ss.send(ss.content(i), -1)
+		}
+	case "TOP":
+		if len(args) != 2 {
+			ss.err("TOP needs a message number and a line count")
+			return false
+		}
+		lines, err := strconv.Atoi(args[1])
+		if err != nil || lines < 0 {
+			ss.err("Bad line count")
+			return false
+		}
+		if i, ok := ss.message(args[0]); ok {
+			ss.ok("Top of message follows")
+			ss.send(ss.content(i), lines)
+		}
+	case "DELE":
+		if len(args) != 1 {
+			ss.err("DELE needs a message number")
+			return false
+		}
+		if i, ok := ss.message(args[0]); ok {
+			ss.editor.Delete(i)
+			ss.ok("Message %d deleted", i+1)
+		}
+	case "RSET":
+		for i := 0; i < ss.len(); i++ {
+			ss.editor.Undelete(i)
+		}
+		ss.ok("Deletions undone")
+	case "NOOP":
+		ss.ok("")
+	default:
+		ss.err("Unknown command %s", cmd)
+	}
+	return false
+}
+
+// pass authenticates the user and opens their mailbox.
+func (ss *session) pass(password string) {
+	if ss.user == "" {
+		ss.err("Send USER first")
+		return
+	}
+	user := ss.user
+	ss.user = ""
+	if ss.srv.Authenticate == nil || !ss.srv.Authenticate(user, password) {
+		// Slow down password guessing.
+		time.Sleep(time.Second)
+		ss.err("[AUTH] Invalid credentials")
+		return
+	}
+
+	timeout := ss.srv.LockTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	e, err := mbox.OpenEditorTimeout(ss.srv.Mailbox(user), timeout)
+	if os.IsNotExist(err) {
+		// Nothing has been delivered to this user yet.
+		ss.authed = true
+		ss.ok("Mailbox open, 0 messages")
+		return
+	}
+	if err == mbox.ErrLockTimeout {
+		ss.err("[IN-USE] Mailbox is locked by another session")
+		return
+	}
+	if err != nil {
+		ss.srv.logf("pop3: %s: %v", user, err)
+		ss.err("[SYS/TEMP] Cannot open mailbox")
+		return
+	}
+	ss.authed, ss.editor = true, e
+	ss.stop = ss.keepFresh(user)
+	ss.sizes = make([]int, e.Len())
+	ss.uidls = make([]string, e.Len())
+	used := make(map[string]bool)
+	for i := range ss.sizes {
+		ss.sizes[i] = -1
+		ss.uidls[i] = uniqueID(e.Message(i), used)
+	}
+	ss.ok("Mailbox open, %d messages", e.Len())
+}
+
+// quit ends the session, first removing deleted messages if the client had
+// logged in.
+func (ss *session) quit() {
+	if ss.editor == nil {
+		ss.ok("Goodbye")
+		return
+	}
+	ss.stop()
+	ss.stop = nil
+	err := ss.editor.Commit()
+	ss.release()
+	if err != nil {
+		ss.srv.logf("pop3: %v", err)
+		ss.err("Some deleted messages not removed")
+		return
+	}
+	ss.ok("Goodbye")
+}
+
+// keepFresh refreshes the editor's dotlock every refreshInterval until the
+// function it answers is called.
+func (ss *session) keepFresh(user string) (stop func()) {
+	e := ss.editor
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(refreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-t.C:
+				if err := e.Refresh(); err != nil {
+					ss.srv.logf("pop3: %s: %v", user, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// release closes the mailbox, if open, discarding any deletions.
+func (ss *session) release() {
+	if ss.stop != nil {
+		ss.stop()
+		ss.stop = nil
+	}
+	if ss.editor != nil {
+		ss.editor.Close()
+		ss.editor = nil
+	}
+}
+
+// message parses a message number, answering its index, or replying with an
+// error if there's no such message.
+func (ss *session) message(arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > ss.len() {
+		ss.err("No such message")
+		return 0, false
+	}
+	if ss.editor.Deleted(n - 1) {
+		ss.err("Message %d already deleted", n)
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// len answers the number of messages in the maildrop, deleted or not.
+func (ss *session) len() int {
+	if ss.editor == nil {
+		return 0
+	}
+	return ss.editor.Len()
+}
+
+// content answers the i'th message as a client sees it: without its From
+// marker line, unescaped, and with CRLF line endings.
+func (ss *session) content(i int) []byte {
+	content := ss.editor.Message(i).WireEML(ss.editor.Dialect())
+	ss.sizes[i] = len(content)
+	return content
+}
+
+// size answers the length of content(i), computing it only once.
+func (ss *session) size(i int) int {
+	if ss.sizes[i] < 0 {
+		ss.content(i)
+	}
+	return ss.sizes[i]
+}
+
+// send writes a message as a multi-line response, byte-stuffing lines which
+// start with a period.  If lines isn't negative, only the header and that
+// many lines of the body are sent.
+func (ss *session) send(content []byte, lines int) {
+	inBody := false
+	for p := 0; p < len(content); {
+		e := bytes.IndexByte(content[p:], '\n') + p + 1
+		if e == p {
+			e = len(content)
+		}
+		line := content[p:e]
+		if inBody {
+			if lines == 0 {
+				break
+			}
+			if lines > 0 {
+				lines--
+			}
+		} else if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			inBody = true
+		}
+		if len(line) > 0 && line[0] == '.' {
+			ss.w.WriteByte('.')
+		}
+		ss.w.Write(line)
+		p = e
+	}
+	if n := len(content); n > 0 && content[n-1] != '\n' {
+		ss.w.WriteString("\r\n")
+	}
+	ss.w.WriteString(".\r\n")
+}
+
+// uniqueID derives a message's UIDL identifier from its envelope and header,
+// which don't change when other messages are deleted.  Identical messages
+// are told apart by a suffix.
+func uniqueID(v *mbox.View, used map[string]bool) string {
+	h := sha256.New()
+	h.Write(v.Envelope)
+	h.Write([]byte{'\n'})
+	h.Write(v.Header)
+	id := hex.EncodeToString(h.Sum(nil)[:16])
+	for n := 2; used[id]; n++ {
+		id = id[:32] + "-" + strconv.Itoa(n)
+	}
+	used[id] = true
+	return id
+}
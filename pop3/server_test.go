@@ -0,0 +1,292 @@
+// vim: ts=8 noexpandtab ai
+
+package pop3
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const inbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: Alice <alice@example.com>
+Subject: Lunch
+
+Shall we?
+>From experience, noon is best.
+
+From carol@example.com Tue Jan  3 09:00:00 2006
+From: Carol <carol@example.com>
+Subject: Dots
+
+One
+.
+..two
+
+From alice@example.com Wed Jan  4 12:00:00 2006
+From: Alice <alice@example.com>
+Subject: Re: Lunch
+
+Tomorrow, then.
+
+`
+
+// A client speaks just enough POP3 to test the server.
+type client struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+// startServer serves a fresh mailbox for bob on the loopback interface, and
+// answers the mailbox's path and a function connecting new clients to it.
+func startServer(t *testing.T) (string, func() *client) {
+	path := filepath.Join(t.TempDir(), "bob")
+	if err := os.WriteFile(path, []byte(inbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback networking: ", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	srv := &Server{
+		Authenticate: func(user, password string) bool {
+			return (user == "bob" || user == "newcomer") && password == "open sesame"
+		},
+		Mailbox:     func(user string) string { return filepath.Join(filepath.Dir(path), user) },
+		LockTimeout: 200 * time.Millisecond,
+	}
+	go srv.Serve(l)
+
+	return path, func() *client {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		cl := &client{t: t, c: c, r: bufio.NewReader(c)}
+		if greeting := cl.line(); !strings.HasPrefix(greeting, "+OK") {
+			t.Fatal("Bad greeting: ", greeting)
+		}
+		return cl
+	}
+}
+
+func (cl *client) line() string {
+	s, err := cl.r.ReadString('\n')
+	if err != nil {
+		cl.t.Fatal(err)
+	}
+	return strings.TrimSuffix(s, "\r\n")
+}
+
+// do sends a command and answers its status line.
+func (cl *client) do(format string, args ...interface{}) string {
+	fmt.Fprintf(cl.c, format+"\r\n", args...)
+	return cl.line()
+}
+
+// ok sends a command, failing the test unless it succeeds.
+func (cl *client) ok(format string, args ...interface{}) string {
+	s := cl.do(format, args...)
+	if !strings.HasPrefix(s, "+OK") {
+		cl.t.Fatalf("%s: %s", fmt.Sprintf(format, args...), s)
+	}
+	return s
+}
+
+// lines sends a command expecting a multi-line response, and answers its
+// lines, unstuffed, without the terminating period.
+func (cl *client) lines(format string, args ...interface{}) []string {
+	cl.ok(format, args...)
+	var lines []string
+	for {
+		s := cl.line()
+		if s == "." {
+			return lines
+		}
+		lines = append(lines, strings.TrimPrefix(s, "."))
+	}
+}
+
+func (cl *client) login() {
+	cl.ok("USER bob")
+	cl.ok("PASS open sesame")
+}
+
+// Given a mailbox of three messages
+// When I log in and list it
+// Then I expect sizes which agree with the messages as retrieved.
+func TestList10(t *testing.T) {
+	_, dial := startServer(t)
+	cl := dial()
+	if s := cl.do("STAT"); !strings.HasPrefix(s, "-ERR") {
+		t.Error("STAT allowed before login: ", s)
+	}
+	cl.login()
+
+	list := cl.lines("LIST")
+	if len(list) != 3 {
+		t.Fatal("Expected 3 messages; got ", list)
+	}
+	total := 0
+	for i, entry := range list {
+		var n, size int
+		fmt.Sscanf(entry, "%d %d", &n, &size)
+		retr := cl.lines("RETR %d", i+1)
+		content := strings.Join(retr, "\r\n") + "\r\n"
+		if n != i+1 || size != len(content) {
+			t.Errorf("LIST says %q; RETR gave %d octets", entry, len(content))
+		}
+		total += size
+	}
+	if s := cl.ok("STAT"); s != fmt.Sprintf("+OK 3 %d", total) {
+		t.Error("STAT wrong: ", s)
+	}
+	if s := cl.ok("LIST 2"); !strings.HasPrefix(s, "+OK 2 ") {
+		t.Error("LIST 2 wrong: ", s)
+	}
+	if s := cl.do("LIST 4"); !strings.HasPrefix(s, "-ERR") {
+		t.Error("LIST 4 should fail: ", s)
+	}
+}
+
+// Given a mailbox with escaped From lines and lines starting with periods
+// When I retrieve messages, whole and in part
+// Then I expect them unescaped, and periods to survive byte-stuffing.
+func TestRetr10(t *testing.T) {
+	_, dial := startServer(t)
+	cl := dial()
+	cl.login()
+
+	retr := cl.lines("RETR 1")
+	if got := retr[len(retr)-1]; got != "From experience, noon is best." {
+		t.Errorf("From line not unescaped: %q", got)
+	}
+	if retr[0] != "From: Alice <alice@example.com>" {
+		t.Errorf("Envelope not removed: %q", retr[0])
+	}
+
+	retr = cl.lines("RETR 2")
+	want := []string{"From: Carol <carol@example.com>", "Subject: Dots", "", "One", ".", "..two"}
+	if strings.Join(retr, "|") != strings.Join(want, "|") {
+		t.Errorf("RETR 2 wrong: %q", retr)
+	}
+
+	top := cl.lines("TOP 2 1")
+	if strings.Join(top, "|") != strings.Join(want[:4], "|") {
+		t.Errorf("TOP 2 1 wrong: %q", top)
+	}
+	top = cl.lines("TOP 2 0")
+	if strings.Join(top, "|") != strings.Join(want[:3], "|") {
+		t.Errorf("TOP 2 0 wrong: %q", top)
+	}
+}
+
+// Given a mailbox of three messages
+// When I list unique IDs, delete messages, reset, and delete again
+// Then I expect only the final deletions removed from the file on QUIT,
+// and the surviving message to keep its unique ID.
+func TestDele10(t *testing.T) {
+	path, dial := startServer(t)
+	cl := dial()
+	cl.login()
+
+	uidl := cl.lines("UIDL")
+	if len(uidl) != 3 {
+		t.Fatal("Expected 3 unique IDs; got ", uidl)
+	}
+	cl.ok("DELE 2")
+	if s := cl.do("RETR 2"); !strings.HasPrefix(s, "-ERR") {
+		t.Error("RETR of a deleted message should fail: ", s)
+	}
+	cl.ok("RSET")
+	cl.ok("DELE 1")
+	cl.ok("DELE 3")
+	if s := cl.ok("STAT"); !strings.HasPrefix(s, "+OK 1 ") {
+		t.Error("STAT should count one message: ", s)
+	}
+	if list := cl.lines("UIDL"); len(list) != 1 || list[0] != uidl[1] {
+		t.Errorf("UIDL after DELE wrong: %q", list)
+	}
+	cl.ok("QUIT")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\nFrom "); !strings.HasPrefix(string(b), "From carol@") || n != 0 {
+		t.Errorf("Mailbox wrong after QUIT:\n%s", b)
+	}
+
+	cl = dial()
+	cl.login()
+	if list := cl.lines("UIDL"); len(list) != 1 || list[0] != "1"+strings.TrimPrefix(uidl[1], "2") {
+		t.Errorf("Unique ID changed: %q", list)
+	}
+}
+
+// Given a mailbox already opened by one session
+// When a second session logs in, and later the first disconnects without QUIT
+// Then I expect the second refused as in use, and no messages removed.
+func TestLock10(t *testing.T) {
+	path, dial := startServer(t)
+	first := dial()
+	first.login()
+	first.ok("DELE 1")
+
+	second := dial()
+	second.ok("USER bob")
+	if s := second.do("PASS open sesame"); !strings.HasPrefix(s, "-ERR [IN-USE]") {
+		t.Error("Second login should find the mailbox in use: ", s)
+	}
+
+	first.c.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		second.ok("USER bob")
+		s := second.do("PASS open sesame")
+		if strings.HasPrefix(s, "+OK") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Mailbox never released: ", s)
+		}
+	}
+	if s := second.ok("STAT"); !strings.HasPrefix(s, "+OK 3 ") {
+		t.Error("Messages lost without QUIT: ", s)
+	}
+	if b, _ := os.ReadFile(path); string(b) != inbox {
+		t.Error("Mailbox changed without QUIT")
+	}
+}
+
+// Given a user to whom nothing has been delivered
+// When they log in
+// Then I expect an empty maildrop, and no mailbox created.
+func TestList20(t *testing.T) {
+	path, dial := startServer(t)
+	cl := dial()
+	cl.ok("USER newcomer")
+	cl.ok("PASS open sesame")
+	if s := cl.ok("STAT"); s != "+OK 0 0" {
+		t.Error("STAT wrong: ", s)
+	}
+	if list := cl.lines("UIDL"); len(list) != 0 {
+		t.Error("UIDL should be empty: ", list)
+	}
+	if s := cl.do("RETR 1"); !strings.HasPrefix(s, "-ERR") {
+		t.Error("RETR 1 should fail: ", s)
+	}
+	cl.ok("QUIT")
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "newcomer")); !os.IsNotExist(err) {
+		t.Error("No mailbox should be created: ", err)
+	}
+}
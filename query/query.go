@@ -0,0 +1,519 @@
+// vim: ts=8 noexpandtab ai
+
+// Package query implements a small query language for selecting messages
+// from an mbox file.  A query consists of terms, implicitly joined by AND:
+//
+//	from:alice subject:"invoice" after:2019-01-01 has:attachment larger:1M
+//
+// The following terms are understood.  Text comparisons ignore case and match
+// substrings.
+//
+//	from:text       the From header
+//	to:text         the To header
+//	cc:text         the Cc header
+//	subject:text    the Subject header
+//	sender:text     the envelope sender, from the From marker line
+//	id:text         the Message-ID header
+//	list:text       the List-Id header
+//	after:date      dated on or after date, given as YYYY-MM-DD
+//	before:date     dated before date
+//	larger:size     larger than size bytes; K, M and G suffixes are allowed
+//	smaller:size    smaller than size bytes
+//	has:attachment  carries at least one attachment
+//	body:text       the decoded text of the body
+//	text            a bare word matches either the subject or the body
+//
+// Terms may be negated with a leading '-' or NOT, combined with OR, and
+// grouped with parentheses.  Values containing spaces must be quoted.
+//
+// Evaluation short-circuits, and terms needing only headers are evaluated
+// before those needing the body.  A message's body is read only if the
+// outcome still depends on it.
+package query
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A Query is a compiled predicate over messages.
+type Query struct {
+	root node
+	text string
+}
+
+// Parse compiles the query text.  An empty query matches every message.
+func Parse(text string) (*Query, error) {
+	p := &parser{toks: tokenize(text)}
+	if len(p.toks) == 0 {
+		return &Query{root: all{}, text: text}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("Unexpected %q in query", p.toks[p.pos].text)
+	}
+	return &Query{root: root, text: text}, nil
+}
+
+// String answers the query text as given to Parse.
+func (q *Query) String() string {
+	return q.text
+}
+
+// NeedsBody answers true if evaluating the query may require reading message
+// bodies.
+func (q *Query) NeedsBody() bool {
+	return q.root.needsBody()
+}
+
+// Match evaluates the query against a message whose body has not yet been
+// read.  If evaluation required the body, Match reads it in full and returns
+// it; otherwise body is nil, and the body remains unread.
+func (q *Query) Match(msg *mbox.Message) (ok bool, body []byte, err error) {
+	c := &candidate{msg: msg, header: msg.MailHeader()}
+	ok, err = q.root.eval(c)
+	return ok, c.body, err
+}
+
+// Filter reads every remaining message from the stream and calls fn with
+// those matching the query, along with their bodies.  Bodies of messages
+// which don't match are skipped without being buffered.
+func Filter(s *mbox.MboxStream, q *Query, fn func(msg *mbox.Message, body []byte) error) error {
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ok, body, err := q.Match(msg)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ok && body == nil:
+			if body, err = io.ReadAll(msg.BodyReader()); err != nil {
+				return err
+			}
+		case !ok && body == nil:
+			if _, err = io.Copy(io.Discard, msg.BodyReader()); err != nil {
+				return err
+			}
+		}
+		if ok {
+			if err = fn(msg, body); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// A candidate holds what is known about the message under evaluation.  Its
+// body and MIME parts are loaded on first use.
+type candidate struct {
+	msg    *mbox.Message
+	header mail.Header
+	body   []byte
+	parts  []mbox.Part
+	parsed bool
+}
+
+func (c *candidate) loadBody() ([]byte, error) {
+	if c.body == nil {
+		b, err := io.ReadAll(c.msg.BodyReader())
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			b = []byte{}
+		}
+		c.body = b
+	}
+	return c.body, nil
+}
+
+func (c *candidate) loadParts() ([]mbox.Part, error) {
+	if !c.parsed {
+		body, err := c.loadBody()
+		if err != nil {
+			return nil, err
+		}
+		c.parts, _ = mbox.Parts(c.header, bytes.NewReader(body))
+		c.parsed = true
+	}
+	return c.parts, nil
+}
+
+// date answers the message's Date header, falling back on its envelope.
+func (c *candidate) date() time.Time {
+	if d, err := c.header.Date(); err == nil {
+		return d
+	}
+	env, _ := c.msg.Envelope()
+	return env.Date
+}
+
+/* *** Predicates *** */
+
+type node interface {
+	eval(c *candidate) (bool, error)
+	needsBody() bool
+}
+
+type all struct{}
+
+func (all) eval(*candidate) (bool, error) { return true, nil }
+func (all) needsBody() bool               { return false }
+
+type and []node
+
+func (n and) eval(c *candidate) (bool, error) {
+	for _, k := range n {
+		if ok, err := k.eval(c); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (n and) needsBody() bool {
+	return anyNeedsBody(n)
+}
+
+type or []node
+
+func (n or) eval(c *candidate) (bool, error) {
+	for _, k := range n {
+		if ok, err := k.eval(c); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (n or) needsBody() bool {
+	return anyNeedsBody(n)
+}
+
+type not struct{ node }
+
+func (n not) eval(c *candidate) (bool, error) {
+	ok, err := n.node.eval(c)
+	return !ok, err
+}
+
+func anyNeedsBody(ns []node) bool {
+	for _, n := range ns {
+		if n.needsBody() {
+			return true
+		}
+	}
+	return false
+}
+
+// headersFirst reorders terms so those answerable from headers alone are
+// evaluated first.
+func headersFirst(ns []node) {
+	sort.SliceStable(ns, func(i, j int) bool {
+		return !ns[i].needsBody() && ns[j].needsBody()
+	})
+}
+
+// headerContains matches a substring of any value of a header.
+type headerContains struct {
+	key, text string
+}
+
+func (n headerContains) eval(c *candidate) (bool, error) {
+	for _, v := range c.header[n.key] {
+		if containsFold(mbox.DecodeHeader(v), n.text) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (headerContains) needsBody() bool { return false }
+
+type senderContains string
+
+func (n senderContains) eval(c *candidate) (bool, error) {
+	env, _ := c.msg.Envelope()
+	return containsFold(env.Sender, string(n)), nil
+}
+
+func (senderContains) needsBody() bool { return false }
+
+// dateRange matches messages dated within [after, before).
+type dateRange struct {
+	after, before time.Time
+}
+
+func (n dateRange) eval(c *candidate) (bool, error) {
+	d := c.date()
+	if d.IsZero() {
+		return false, nil
+	}
+	if !n.after.IsZero() && d.Before(n.after) {
+		return false, nil
+	}
+	if !n.before.IsZero() && !d.Before(n.before) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (dateRange) needsBody() bool { return false }
+
+// sizeRange compares the size of the whole message, in bytes, as stored in
+// the mailbox.
+type sizeRange struct {
+	larger bool
+	size   int64
+}
+
+func (n sizeRange) eval(c *candidate) (bool, error) {
+	body, err := c.loadBody()
+	if err != nil {
+		return false, err
+	}
+	size := int64(len(c.msg.RawHeader()) + len(body))
+	if n.larger {
+		return size > n.size, nil
+	}
+	return size < n.size, nil
+}
+
+func (sizeRange) needsBody() bool { return true }
+
+type hasAttachment struct{}
+
+func (hasAttachment) eval(c *candidate) (bool, error) {
+	// A single-part message may be an attachment in its own right, so
+	// every message's parts must be examined.
+	parts, err := c.loadParts()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range parts {
+		if p.Attachment {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (hasAttachment) needsBody() bool { return true }
+
+type bodyContains string
+
+func (n bodyContains) eval(c *candidate) (bool, error) {
+	parts, err := c.loadParts()
+	if err != nil {
+		return false, err
+	}
+	return containsFold(mbox.Text(parts), string(n)), nil
+}
+
+func (bodyContains) needsBody() bool { return true }
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+/* *** Parsing *** */
+
+type token struct {
+	text   string
+	quoted bool
+	keyed  bool
+}
+
+// tokenize splits query text into words, quoted strings, parentheses, and
+// negation signs.  A quoted value directly following a key, as in
+// subject:"two words", is kept in the same token.
+func tokenize(s string) (toks []token) {
+	for i := 0; i < len(s); {
+		switch ch := s[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(' || ch == ')':
+			toks = append(toks, token{text: s[i : i+1]})
+			i++
+		case ch == '-' && i+1 < len(s) && s[i+1] != ' ':
+			toks = append(toks, token{text: "-"})
+			i++
+		default:
+			var b strings.Builder
+			quoted, keyed := false, false
+			for i < len(s) && !strings.ContainsRune(" \t\r\n()", rune(s[i])) {
+				if s[i] == ':' && !quoted {
+					keyed = true
+				}
+				if s[i] == '"' {
+					quoted = true
+					j := strings.IndexByte(s[i+1:], '"')
+					if j < 0 {
+						j = len(s) - i - 1
+					}
+					b.WriteString(s[i+1 : i+1+j])
+					i += j + 2
+					continue
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			toks = append(toks, token{text: b.String(), quoted: quoted, keyed: keyed})
+		}
+	}
+	return
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos], true
+	}
+	return token{}, false
+}
+
+func (p *parser) parseOr() (node, error) {
+	var terms or
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+		t, ok := p.peek()
+		if !ok || t.quoted || t.text != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	headersFirst(terms)
+	return terms, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	var terms and
+	for {
+		t, ok := p.peek()
+		if !ok || (!t.quoted && (t.text == ")" || t.text == "OR")) {
+			break
+		}
+		if !t.quoted && t.text == "AND" {
+			p.pos++
+			continue
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+	}
+	switch len(terms) {
+	case 0:
+		return nil, fmt.Errorf("Search term expected")
+	case 1:
+		return terms[0], nil
+	}
+	headersFirst(terms)
+	return terms, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("Unexpected end of query")
+	}
+	p.pos++
+	switch {
+	case t.quoted:
+		return p.term(t)
+	case t.text == "-" || t.text == "NOT":
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{n}, nil
+	case t.text == "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := p.peek(); !ok || t.text != ")" {
+			return nil, fmt.Errorf("Closing parenthesis expected")
+		}
+		p.pos++
+		return n, nil
+	case t.text == ")" || t.text == "OR":
+		return nil, fmt.Errorf("Unexpected %s", t.text)
+	}
+	return p.term(t)
+}
+
+// term builds the predicate for a single key:value term or bare word.
+func (p *parser) term(t token) (node, error) {
+	if !t.keyed {
+		return or{headerContains{"Subject", t.text}, bodyContains(t.text)}, nil
+	}
+	key, value, _ := strings.Cut(t.text, ":")
+
+	switch strings.ToLower(key) {
+	case "from", "to", "cc", "subject":
+		return headerContains{mailKey(key), value}, nil
+	case "id":
+		return headerContains{"Message-Id", value}, nil
+	case "list":
+		return headerContains{"List-Id", value}, nil
+	case "sender":
+		return senderContains(value), nil
+	case "body":
+		return bodyContains(value), nil
+	case "after", "before":
+		d, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("Date expected for %s: %v", key, err)
+		}
+		if strings.ToLower(key) == "after" {
+			return dateRange{after: d}, nil
+		}
+		return dateRange{before: d}, nil
+	case "larger", "smaller":
+		n, err := mbox.ParseSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("Size expected for %s: %v", key, err)
+		}
+		return sizeRange{larger: strings.ToLower(key) == "larger", size: n}, nil
+	case "has":
+		if strings.ToLower(value) == "attachment" {
+			return hasAttachment{}, nil
+		}
+		return nil, fmt.Errorf("Unknown has: term %q", value)
+	}
+	return nil, fmt.Errorf("Unknown search key %q", key)
+}
+
+// mailKey canonicalizes a header name the way mail.Header expects.
+func mailKey(key string) string {
+	return strings.ToUpper(key[:1]) + strings.ToLower(key[1:])
+}
@@ -0,0 +1,138 @@
+// vim: ts=8 noexpandtab ai
+
+package query
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sam-falvo/mbox"
+)
+
+const mailbox = `From alice@example.com Mon Jan  2 15:04:05 2006
+From: Alice <alice@example.com>
+To: Bob <bob@example.com>
+Subject: Invoice for January
+Date: Mon, 2 Jan 2006 15:04:05 +0000
+Content-Type: multipart/mixed; boundary="B"
+
+--B
+Content-Type: text/plain
+
+Please find the invoice attached.
+--B
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="invoice.pdf"
+
+%PDF
+--B--
+
+From bob@example.com Tue Mar  6 09:00:00 2018
+From: Bob <bob@example.com>
+To: Alice <alice@example.com>
+Subject: Lunch?
+Date: Tue, 6 Mar 2018 09:00:00 +0000
+
+Are you free for lunch on Thursday?
+
+From carol@example.com Wed Apr  3 12:00:00 2019
+From: Carol <carol@example.com>
+To: Alice <alice@example.com>
+Subject: Receipt
+Date: Wed, 3 Apr 2019 12:00:00 +0000
+Content-Type: application/pdf
+Content-Disposition: attachment; filename="receipt.pdf"
+
+%PDF
+`
+
+// subjectsMatching runs a query over the test mailbox and answers the subjects
+// of the matching messages, joined by commas.
+func subjectsMatching(t *testing.T, text string) string {
+	q, err := Parse(text)
+	if err != nil {
+		t.Fatal(text, ": ", err)
+	}
+	s, err := mbox.CreateMboxStream(strings.NewReader(mailbox))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	err = Filter(s, q, func(msg *mbox.Message, body []byte) error {
+		subjects = append(subjects, msg.MailHeader().Get("Subject"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(text, ": ", err)
+	}
+	return strings.Join(subjects, ",")
+}
+
+// Given a variety of queries
+// When I filter the test mailbox
+// Then I expect the right messages to match.
+func TestQuery10(t *testing.T) {
+	cases := map[string]string{
+		``:                                   "Invoice for January,Lunch?,Receipt",
+		`from:alice`:                         "Invoice for January",
+		`subject:"invoice for"`:              "Invoice for January",
+		`after:2010-01-01`:                   "Lunch?,Receipt",
+		`after:2010-01-01 has:attachment`:    "Receipt",
+		`before:2010-01-01 has:attachment`:   "Invoice for January",
+		`-has:attachment`:                    "Lunch?",
+		`thursday`:                           "Lunch?",
+		`body:attached OR subject:lunch`:     "Invoice for January,Lunch?",
+		`(from:bob OR from:carol) larger:10`: "Lunch?,Receipt",
+		`smaller:1K NOT sender:bob@`:         "Invoice for January,Receipt",
+		`to:alice larger:1M`:                 "",
+	}
+	for text, expected := range cases {
+		if got := subjectsMatching(t, text); got != expected {
+			t.Errorf("%s: expected %q; got %q", text, expected, got)
+		}
+	}
+}
+
+// Given a query needing only headers
+// When I match a message
+// Then I expect its body to remain unread.
+func TestQuery20(t *testing.T) {
+	q, _ := Parse(`from:alice after:2000-01-01`)
+	if q.NeedsBody() {
+		t.Error("Header-only query shouldn't need bodies")
+	}
+	s, _ := mbox.CreateMboxStream(strings.NewReader(mailbox))
+	msg, _ := s.ReadMessage()
+	ok, body, err := q.Match(msg)
+	if !ok || body != nil || err != nil {
+		t.Fatal("Unexpected result: ", ok, body, err)
+	}
+	rest, _ := io.ReadAll(msg.BodyReader())
+	if !strings.HasPrefix(string(rest), "--B\n") {
+		t.Errorf("Body should be unread; got %q", rest)
+	}
+}
+
+// Given malformed queries
+// When I parse them
+// Then I expect errors.
+func TestQuery30(t *testing.T) {
+	for _, text := range []string{`(from:a`, `after:yesterday`, `larger:lots`, `colour:red`, `has:wings`, `from:a )`,
+		`from:x NOT`, `from:x (`, `from:x -`, `NOT )`, `- OR from:x`} {
+		if _, err := Parse(text); err == nil {
+			t.Error("Expected error parsing ", text)
+		}
+	}
+}
+
+// Given queries which stop short
+// When I parse them
+// Then I expect to be told the query ended unexpectedly.
+func TestQuery40(t *testing.T) {
+	for _, text := range []string{`from:x NOT`, `from:x -`, `NOT`} {
+		if _, err := Parse(text); err == nil || err.Error() != "Unexpected end of query" {
+			t.Errorf("%s: expected end of query error; got %v", text, err)
+		}
+	}
+}
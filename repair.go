@@ -0,0 +1,107 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// Repair copies a mailbox, correcting what problems it can.  Every problem
+// found in the original is first reported to fn, as by Validate; fn may be
+// nil.
+//
+// Messages are delimited just as Validate found them, using the original
+// Content-Length headers.  Line endings then become LF.  A stray From line,
+// which Validate reports as UnescapedFrom, is escaped and joined to the
+// message before it.  A From line inside a body delimited by Content-Length is
+// escaped too, unless the mailbox's dialect is Mboxcl or Mboxcl2, which permit
+// it.  Messages are separated by blank lines, header blocks end with one, and
+// the mailbox ends with a line terminator.  Content-Length headers, where
+// present, are rewritten to match the body.  Malformed headers and overlong
+// lines are copied unchanged.
+func Repair(r io.Reader, w io.Writer, fn func(Problem)) error {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if fn == nil {
+		fn = func(Problem) {}
+	}
+	d, _ := DetectDialect(bytes.NewReader(buf))
+	if err = ValidateDialect(buf, d, fn); err != nil {
+		return err
+	}
+	escape := func(body []byte) []byte {
+		if d.usesContentLength() {
+			return body
+		}
+		return escapeFromLines(body)
+	}
+
+	out := bufio.NewWriter(w)
+	var envelope, header, body []byte
+	var prev []byte
+	s := NewScanner(buf)
+	for s.Next() {
+		v := s.View()
+		if prev != nil && !endsWithBlankLine(prev) && isStrayFrom(v) {
+			body = append(body, escapeFromLines(toLF(v.Raw))...)
+		} else {
+			if prev != nil {
+				writeRepaired(out, envelope, header, body)
+			}
+			envelope, header, body = v.Envelope, toLF(v.Header), escape(toLF(v.Body))
+		}
+		prev = v.Raw
+	}
+	if prev != nil {
+		writeRepaired(out, envelope, header, body)
+	}
+	return out.Flush()
+}
+
+// writeRepaired writes a single message in canonical form: envelope, header
+// block, blank line, body, and a blank line to separate it from the next.
+func writeRepaired(out *bufio.Writer, envelope, header, body []byte) {
+	if n := len(body); n > 0 && body[n-1] != '\n' {
+		body = append(body, '\n')
+	}
+	if bytes.Equal(body, []byte("\n")) || bytes.HasSuffix(body, []byte("\n\n")) {
+		body = body[:len(body)-1]
+	}
+	if n := len(header); n > 0 && header[n-1] != '\n' {
+		header = append(header[:n:n], '\n')
+	}
+	if headerValue(header, "Content-Length") != nil {
+		header = append(removeField(header, "Content-Length"), "Content-Length: "+strconv.Itoa(len(body))+"\n"...)
+	}
+
+	out.Write(envelope)
+	out.WriteByte('\n')
+	out.Write(header)
+	out.WriteByte('\n')
+	out.Write(body)
+	out.WriteByte('\n')
+}
+
+// escapeFromLines answers a copy of buf with a '>' inserted before each line
+// starting with "From ".  This is the correct escape for Mboxo and Mboxrd
+// alike.
+func escapeFromLines(buf []byte) []byte {
+	out := make([]byte, 0, len(buf))
+	forEachLine(buf, func(line []byte) {
+		if isFromLine(line) {
+			out = append(out, '>')
+		}
+		out = append(out, line...)
+	})
+	return out
+}
+
+// toLF answers a copy of buf with CRLF line endings converted to LF.
+func toLF(buf []byte) []byte {
+	return bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))
+}
@@ -0,0 +1,282 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrNotMbox indicates that the input given doesn't start with a From marker,
+// and so cannot be an mbox file.
+var ErrNotMbox = errors.New("Input does not start with a From marker")
+
+// A View refers to a single message held inside a larger buffer.  None of its
+// fields own their memory; each slice references the buffer handed to the
+// Scanner which produced the view.  Modifying the buffer will modify the
+// view, and vice versa.
+type View struct {
+	// Offset locates the message's From marker inside the buffer.
+	Offset int64
+
+	// Raw spans the whole message, from the first byte of its From marker
+	// up to, but excluding, the From marker of the next message.
+	Raw []byte
+
+	// Envelope holds the From marker line, excluding its line terminator.
+	Envelope []byte
+
+	// Header holds the header block, excluding the blank line which
+	// terminates it.
+	Header []byte
+
+	// Body holds everything following the blank line after the header
+	// block, up to the next message.  Like the body produced by
+	// Message.BodyReader(), this includes any blank line separating this
+	// message from the next.
+	Body []byte
+}
+
+// A Scanner splits a buffer holding an entire mbox file into messages without
+// copying or allocating per message.  It works equally well on ordinary byte
+// slices and on memory-mapped files.
+//
+// Where a message carries a Content-Length header which correctly identifies
+// the start of the next message, the Scanner honors it; this lets it read
+// mboxcl2 files whose bodies contain unescaped From lines.  Otherwise, as
+// with MboxStream, any line starting with "From " begins a new message.
+type Scanner struct {
+	buf  []byte
+	pos  int
+	view View
+	err  error
+}
+
+// NewScanner creates a Scanner over the given buffer.
+func NewScanner(buf []byte) *Scanner {
+	s := &Scanner{buf: buf}
+	if len(buf) > 0 && !isFromLine(buf) {
+		s.err = ErrNotMbox
+	}
+	return s
+}
+
+// Next advances the scanner to the next message, which then becomes available
+// through View().  It returns false once no messages remain, or if the buffer
+// isn't an mbox file; Err() distinguishes the two cases.
+func (s *Scanner) Next() bool {
+	if s.err != nil || s.pos >= len(s.buf) {
+		return false
+	}
+
+	buf := s.buf
+	start := s.pos
+
+	eol := lineEnd(buf, start)
+	envelope := trimEOL(buf[start:eol])
+
+	// Walk the header block a line at a time, looking for the blank line
+	// which ends it.  A From marker also ends it, in which case the message
+	// has no body at all.
+	headerStart := eol
+	headerEnd := eol
+	bodyStart := eol
+	for p := eol; p < len(buf); {
+		e := lineEnd(buf, p)
+		line := buf[p:e]
+		if isBlankLine(line) {
+			headerEnd = p
+			bodyStart = e
+			break
+		}
+		if isFromLine(line) {
+			headerEnd = p
+			bodyStart = p
+			break
+		}
+		p = e
+		headerEnd = p
+		bodyStart = p
+	}
+
+	end := s.contentLengthEnd(buf[headerStart:headerEnd], bodyStart)
+	if end < 0 {
+		end = len(buf)
+		if k := bytes.Index(buf[bodyStart-1:], newlineFromMarker); k >= 0 {
+			end = bodyStart - 1 + k + 1
+		}
+	}
+
+	s.view = View{
+		Offset:   int64(start),
+		Raw:      buf[start:end],
+		Envelope: envelope,
+		Header:   buf[headerStart:headerEnd],
+		Body:     buf[bodyStart:end],
+	}
+	s.pos = end
+	return true
+}
+
+// contentLengthEnd answers where a message ends according to its
+// Content-Length header.  If the header is missing, malformed, or doesn't lead
+// to either the end of the buffer or another From marker (optionally preceded
+// by a blank line), it returns -1.
+func (s *Scanner) contentLengthEnd(header []byte, bodyStart int) int {
+	v := headerValue(header, "Content-Length")
+	if v == nil {
+		return -1
+	}
+	n, ok := parseDecimal(v)
+	if !ok {
+		return -1
+	}
+	end := bodyStart + n
+	if end > len(s.buf) {
+		return -1
+	}
+	if end == len(s.buf) || isFromLine(s.buf[end:]) {
+		return end
+	}
+	if e := lineEnd(s.buf, end); isBlankLine(s.buf[end:e]) && (e == len(s.buf) || isFromLine(s.buf[e:])) {
+		return e
+	}
+	return -1
+}
+
+// View provides the message most recently found by Next().  The View is
+// overwritten by the next call to Next(); copy it if you need to keep it.
+func (s *Scanner) View() *View {
+	return &s.view
+}
+
+// Err reports the error, if any, which stopped the scanner.  Reaching the end
+// of the buffer is not an error.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Sender answers the text following the From marker, with surrounding
+// whitespace removed.  This matches Message.Sender().
+func (v *View) Sender() []byte {
+	return bytes.TrimSpace(v.Envelope[len(fromMarker):])
+}
+
+// Fields calls fn for each header field of the message, in the order they
+// appear.  The value excludes the colon and any whitespace following it; for
+// folded headers, it spans all continuation lines verbatim, up to but
+// excluding the final line terminator.  Iteration stops early if fn returns
+// false.  Lines which don't look like headers are skipped.
+func (v *View) Fields(fn func(name, value []byte) bool) {
+	forEachField(v.Header, fn)
+}
+
+// Get answers the value of the first header field with the given name,
+// compared without regard to case, or nil if no such field exists.  Leading
+// and trailing whitespace is removed.
+func (v *View) Get(name string) []byte {
+	return headerValue(v.Header, name)
+}
+
+// forEachField implements View.Fields for any raw header block.
+func forEachField(header []byte, fn func(name, value []byte) bool) {
+	p := 0
+	for p < len(header) {
+		e := lineEnd(header, p)
+		line := header[p:e]
+		k := bytes.IndexByte(line, ':')
+		if k < 1 || isspace(line[0]) {
+			p = e
+			continue
+		}
+		for e < len(header) && isspace(header[e]) && !isBlankLine(header[e:lineEnd(header, e)]) {
+			e = lineEnd(header, e)
+		}
+		value := trimEOL(header[p+k+1 : e])
+		for len(value) > 0 && (value[0] == ' ' || value[0] == '\t') {
+			value = value[1:]
+		}
+		if !fn(line[:k], value) {
+			return
+		}
+		p = e
+	}
+}
+
+// headerValue finds the first field of the given name within a raw header
+// block.
+func headerValue(header []byte, name string) (found []byte) {
+	forEachField(header, func(n, v []byte) bool {
+		if equalFold(n, name) {
+			found = bytes.TrimSpace(v)
+			if found == nil {
+				found = []byte{}
+			}
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// equalFold compares a byte slice with a string without regard to ASCII case,
+// and without converting either.
+func equalFold(b []byte, s string) bool {
+	if len(b) != len(s) {
+		return false
+	}
+	for i := 0; i < len(b); i++ {
+		x, y := b[i], s[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
+var newlineFromMarker = []byte("\nFrom ")
+
+// lineEnd answers the offset just past the line terminator of the line
+// starting at p, or the end of the buffer if the last line is unterminated.
+func lineEnd(buf []byte, p int) int {
+	if k := bytes.IndexByte(buf[p:], '\n'); k >= 0 {
+		return p + k + 1
+	}
+	return len(buf)
+}
+
+// trimEOL removes a trailing LF or CRLF line terminator.
+func trimEOL(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+	}
+	return line
+}
+
+// isBlankLine answers true if the line consists of nothing but its terminator.
+func isBlankLine(line []byte) bool {
+	return len(line) > 0 && len(trimEOL(line)) == 0
+}
+
+// parseDecimal converts a non-negative decimal number without allocating.
+func parseDecimal(b []byte) (n int, ok bool) {
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
@@ -0,0 +1,176 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const mboxcl2WithUnescapedFrom = `From foo@bar.com
+Subject: First
+Content-Length: 38
+
+Quoting the classics:
+From here on...
+
+From baz@bar.com
+Subject: Second
+
+Done.
+`
+
+// Given a valid mbox file with three messages
+// When I scan it
+// Then I expect the same messages, offsets and bodies MboxStream finds.
+func TestScanner10(t *testing.T) {
+	offsets, subjects := readSequentially(t, mboxWith3Messages)
+
+	s := NewScanner([]byte(mboxWith3Messages))
+	i := 0
+	var raw []byte
+	for s.Next() {
+		v := s.View()
+		if v.Offset != offsets[i] {
+			t.Errorf("Message %d: expected offset %d, got %d", i, offsets[i], v.Offset)
+		}
+		if string(v.Get("subject")) != subjects[i] {
+			t.Errorf("Message %d: expected subject %q, got %q", i, subjects[i], v.Get("subject"))
+		}
+		if string(v.Sender()) != "foo@bar.com" {
+			t.Errorf("Message %d: wrong sender %q", i, v.Sender())
+		}
+		raw = append(raw, v.Raw...)
+		i++
+	}
+	if s.Err() != nil {
+		t.Error("TestScanner10: ", s.Err())
+	}
+	if i != 3 {
+		t.Error("Expected 3 messages; got ", i)
+	}
+	if string(raw) != mboxWith3Messages {
+		t.Error("Concatenated views should reproduce the input exactly")
+	}
+}
+
+// Given a message with a folded header
+// When I iterate over its fields
+// Then I expect the folded value to span its continuation lines.
+func TestScanner20(t *testing.T) {
+	s := NewScanner([]byte(mboxWithMessage3Headers))
+	if !s.Next() {
+		t.Fatal("Expected a message")
+	}
+	var names []string
+	var to string
+	s.View().Fields(func(name, value []byte) bool {
+		names = append(names, string(name))
+		if string(name) == "To" {
+			to = string(value)
+		}
+		return true
+	})
+	if strings.Join(names, ",") != "From,To,Subject" {
+		t.Error("Unexpected field names: ", names)
+	}
+	if !strings.HasPrefix(to, "user1@bar.com\n user2@bar.com") || !strings.HasSuffix(to, "user5@bar.com") {
+		t.Errorf("Folded value wrong: %q", to)
+	}
+	if string(s.View().Body) != "Greetings and hallucinations!\n" {
+		t.Errorf("Body wrong: %q", s.View().Body)
+	}
+}
+
+// Given an mboxcl2 file whose body contains an unescaped From line
+// When I scan it
+// Then I expect Content-Length to keep the body intact.
+func TestScanner30(t *testing.T) {
+	s := NewScanner([]byte(mboxcl2WithUnescapedFrom))
+	var subjects []string
+	for s.Next() {
+		subjects = append(subjects, string(s.View().Get("Subject")))
+	}
+	if strings.Join(subjects, ",") != "First,Second" {
+		t.Error("Expected two messages; got ", subjects)
+	}
+}
+
+// Given input which isn't an mbox file
+// When I scan it
+// Then I expect ErrNotMbox.
+func TestScanner40(t *testing.T) {
+	s := NewScanner([]byte("Subject: nope\n"))
+	if s.Next() || s.Err() != ErrNotMbox {
+		t.Error("Expected ErrNotMbox; got ", s.Err())
+	}
+}
+
+/* *** Benchmarks *** */
+
+// benchmarkMbox returns a mailbox of roughly 8 MiB for throughput tests.
+func benchmarkMbox() []byte {
+	var b bytes.Buffer
+	body := strings.Repeat("The quick brown fox jumps over the lazy dog, again and again.\n", 40)
+	for b.Len() < 8<<20 {
+		b.WriteString("From someone@example.com Mon Jan  2 15:04:05 2006\n")
+		b.WriteString("Return-Path: <someone@example.com>\n")
+		b.WriteString("Received: from mx.example.com (mx.example.com [192.0.2.1])\n\tby mail.example.org; Mon, 2 Jan 2006 15:04:05 -0700\n")
+		b.WriteString("From: Someone <someone@example.com>\n")
+		b.WriteString("To: Anyone <anyone@example.org>\n")
+		b.WriteString("Subject: Benchmarking the scanner\n")
+		b.WriteString("Message-ID: <1234567890@example.com>\n\n")
+		b.WriteString(body)
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
+func BenchmarkScanner(b *testing.B) {
+	buf := benchmarkMbox()
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(buf)
+		for s.Next() {
+		}
+	}
+}
+
+func BenchmarkScannerFields(b *testing.B) {
+	buf := benchmarkMbox()
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(buf)
+		for s.Next() {
+			s.View().Fields(func(name, value []byte) bool {
+				return true
+			})
+		}
+	}
+}
+
+func BenchmarkMboxStream(b *testing.B) {
+	buf := benchmarkMbox()
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mr, err := CreateMboxStream(bytes.NewReader(buf))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			msg, err := mr.ReadMessage()
+			if err != nil {
+				break
+			}
+			io.Copy(io.Discard, msg.BodyReader())
+		}
+	}
+}
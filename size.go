@@ -0,0 +1,29 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a byte count such as 512, 10K, 2M or 1G.  The suffixes,
+// in either case, multiply by powers of 1024.
+func ParseSize(s string) (int64, error) {
+	mult := int64(1)
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		}
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n * mult, err
+}
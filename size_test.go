@@ -0,0 +1,37 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import "testing"
+
+// Given byte counts with and without suffixes
+// When I parse them
+// Then I expect them scaled by powers of 1024.
+func TestParseSize10(t *testing.T) {
+	cases := map[string]int64{
+		"512": 512,
+		"10K": 10 << 10,
+		"2m":  2 << 20,
+		"1G":  1 << 30,
+	}
+	for s, expected := range cases {
+		n, err := ParseSize(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+		if n != expected {
+			t.Errorf("%s: Expected %d; got %d", s, expected, n)
+		}
+	}
+}
+
+// Given an empty or malformed byte count
+// When I parse it
+// Then I expect an error.
+func TestParseSize20(t *testing.T) {
+	for _, s := range []string{"", "K", "ten", "10X"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("%q: Expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A SplitRule assigns each message to a named output mailbox.  It is given the
+// message, whose body has already been read, and the size of the message in
+// bytes.  Rules may keep state between calls, so each Split should use a
+// fresh rule.
+type SplitRule func(msg *Message, size int64) string
+
+// SplitEvery starts a new mailbox every n messages.  Mailboxes are named by
+// sequence number: "0001", "0002", and so on.
+func SplitEvery(n int) SplitRule {
+	count, seq := 0, 1
+	return func(*Message, int64) string {
+		if count == n {
+			count, seq = 0, seq+1
+		}
+		count++
+		return fmt.Sprintf("%04d", seq)
+	}
+}
+
+// SplitBySize starts a new mailbox whenever adding the next message would
+// take the current one beyond n bytes.  Messages larger than n bytes get a
+// mailbox to themselves.  Mailboxes are named as for SplitEvery.
+func SplitBySize(n int64) SplitRule {
+	var used int64
+	seq := 1
+	return func(_ *Message, size int64) string {
+		if used > 0 && used+size > n {
+			used, seq = 0, seq+1
+		}
+		used += size
+		return fmt.Sprintf("%04d", seq)
+	}
+}
+
+// SplitByYear sorts messages into mailboxes named by year, such as "2006",
+// using the Date header or, failing that, the envelope date.  Undated
+// messages go into "undated".
+func SplitByYear() SplitRule {
+	return splitByDate("2006")
+}
+
+// SplitByMonth sorts messages into mailboxes named by month, such as
+// "2006-01", as SplitByYear does.
+func SplitByMonth() SplitRule {
+	return splitByDate("2006-01")
+}
+
+func splitByDate(layout string) SplitRule {
+	return func(msg *Message, _ int64) string {
+		d, err := msg.Date()
+		if err != nil {
+			env, _ := msg.Envelope()
+			d = env.Date
+		}
+		if d.IsZero() {
+			return "undated"
+		}
+		return d.Format(layout)
+	}
+}
+
+// SplitBySenderDomain sorts messages into mailboxes named after the domain of
+// the From address, such as "example.com", falling back on the envelope
+// sender.  Messages whose sender cannot be determined go into "unknown".
+func SplitBySenderDomain() SplitRule {
+	return func(msg *Message, _ int64) string {
+		addr := ""
+		if list, err := msg.AddressList("From"); err == nil && len(list) > 0 {
+			addr = list[0].Address
+		} else if env, _ := msg.Envelope(); env.Sender != "" {
+			addr = env.Sender
+		}
+		if k := strings.LastIndex(addr, "@"); k >= 0 && k+1 < len(addr) {
+			return safeFileName(strings.ToLower(addr[k+1:]))
+		}
+		return "unknown"
+	}
+}
+
+// SplitByListID sorts messages into mailboxes named after the identifier in
+// their List-Id header, such as "golang-nuts.googlegroups.com".  Messages
+// from no list go into "none".
+func SplitByListID() SplitRule {
+	return func(msg *Message, _ int64) string {
+		id := msg.MailHeader().Get("List-Id")
+		if i, j := strings.LastIndex(id, "<"), strings.LastIndex(id, ">"); i >= 0 && j > i {
+			id = id[i+1 : j]
+		}
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			return "none"
+		}
+		return safeFileName(id)
+	}
+}
+
+// splitMaxOpen bounds how many output mailboxes Split keeps open at once.
+var splitMaxOpen = 64
+
+// Split reads every remaining message of the stream and copies it, byte for
+// byte, into the output mailbox chosen by the rule.  Split answers how many
+// messages went into each mailbox.
+//
+// The open function is called with first set the first time the rule
+// produces a name, to create that mailbox.  So as not to run out of file
+// descriptors, Split keeps only a few mailboxes open, closing the least
+// recently used; should the rule produce its name again, open is called
+// without first, and must answer a writer appending to the same mailbox.
+// Split closes everything it opens before it returns.
+func Split(s *MboxStream, rule SplitRule, open func(name string, first bool) (io.WriteCloser, error)) (counts map[string]int, err error) {
+	outputs := make(map[string]*list.Element)
+	recent := list.New()
+	defer func() {
+		for el := recent.Front(); el != nil; el = el.Next() {
+			if e := el.Value.(*splitOutput).w.Close(); err == nil {
+				err = e
+			}
+		}
+	}()
+
+	counts = make(map[string]int)
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return counts, err
+		}
+
+		var raw bytes.Buffer
+		if _, err := msg.WriteTo(&raw); err != nil {
+			return counts, err
+		}
+
+		name := rule(msg, int64(raw.Len()))
+		e, ok := outputs[name]
+		if ok {
+			recent.MoveToFront(e)
+		} else {
+			if recent.Len() >= splitMaxOpen {
+				last := recent.Remove(recent.Back()).(*splitOutput)
+				delete(outputs, last.name)
+				if err := last.w.Close(); err != nil {
+					return counts, err
+				}
+			}
+			_, seen := counts[name]
+			w, err := open(name, !seen)
+			if err != nil {
+				return counts, err
+			}
+			e = recent.PushFront(&splitOutput{name: name, w: w})
+			outputs[name] = e
+		}
+		if _, err := e.Value.(*splitOutput).w.Write(raw.Bytes()); err != nil {
+			return counts, err
+		}
+		counts[name]++
+	}
+}
+
+// A splitOutput is one of the mailboxes Split holds open.
+type splitOutput struct {
+	name string
+	w    io.WriteCloser
+}
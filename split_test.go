@@ -0,0 +1,122 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const mboxToSplit = `From a@one.com Mon Jan  2 10:00:00 2006
+From: A <a@one.com>
+List-Id: Gophers <gophers.example.org>
+Date: Mon, 2 Jan 2006 10:00:00 +0000
+
+One.
+
+From b@two.com Tue Jan  2 10:00:00 2007
+From: B <b@Two.com>
+Date: Tue, 2 Jan 2007 10:00:00 +0000
+
+Two.
+
+From a@one.com Sun Feb  4 10:00:00 2007
+From: A <a@one.com>
+List-Id: <gophers.example.org>
+
+Three, dated only by its envelope.
+`
+
+// A splitBuffer stands in for an output file, counting how many are open.
+type splitBuffer struct {
+	*bytes.Buffer
+	open *int
+}
+
+func (b splitBuffer) Close() error {
+	*b.open--
+	return nil
+}
+
+// splitWith splits the test mailbox by the given rule, and answers the
+// resulting mailboxes along with the most that were ever open at once.
+func splitWith(t *testing.T, rule SplitRule) (map[string]*bytes.Buffer, int) {
+	outputs := make(map[string]*bytes.Buffer)
+	open, most := 0, 0
+	withOpenMboxStream(t, "splitWith", mboxToSplit, func(mr *MboxStream) {
+		_, err := Split(mr, rule, func(name string, first bool) (io.WriteCloser, error) {
+			if _, ok := outputs[name]; ok == first {
+				t.Errorf("%s: first is %v, yet the mailbox exists: %v", name, first, ok)
+			}
+			if first {
+				outputs[name] = &bytes.Buffer{}
+			}
+			open++
+			most = max(most, open)
+			return splitBuffer{outputs[name], &open}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if open != 0 {
+		t.Error("Split left mailboxes open: ", open)
+	}
+	return outputs, most
+}
+
+func names(outputs map[string]*bytes.Buffer) string {
+	var ns []string
+	for n := range outputs {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return strings.Join(ns, ",")
+}
+
+// Given a mailbox of three messages
+// When I split it by each rule
+// Then I expect the messages to land in the right mailboxes, intact.
+func TestSplit10(t *testing.T) {
+	expected := map[string]SplitRule{
+		"0001,0002":                SplitEvery(2),
+		"0001,0002,0003":           SplitBySize(10),
+		"2006,2007":                SplitByYear(),
+		"2006-01,2007-01,2007-02":  SplitByMonth(),
+		"one.com,two.com":          SplitBySenderDomain(),
+		"gophers.example.org,none": SplitByListID(),
+	}
+	for want, rule := range expected {
+		outputs, _ := splitWith(t, rule)
+		if got := names(outputs); got != want {
+			t.Errorf("Expected mailboxes %s; got %s", want, got)
+		}
+		var total int
+		for _, b := range outputs {
+			total += b.Len()
+		}
+		if total != len(mboxToSplit) {
+			t.Error("Split should preserve every byte; lost ", len(mboxToSplit)-total)
+		}
+	}
+}
+
+// Given more output mailboxes than Split may keep open
+// When I split a mailbox
+// Then I expect mailboxes closed and reopened for append, losing nothing.
+func TestSplit20(t *testing.T) {
+	defer func(n int) { splitMaxOpen = n }(splitMaxOpen)
+	splitMaxOpen = 1
+
+	outputs, most := splitWith(t, SplitBySenderDomain())
+	if most != 1 {
+		t.Error("Expected at most one mailbox open; got ", most)
+	}
+	one := outputs["one.com"].String()
+	if strings.Count(one, "From a@one.com") != 2 || !strings.HasSuffix(one, "Three, dated only by its envelope.\n") {
+		t.Errorf("Expected both messages from one.com; got:\n%s", one)
+	}
+}
@@ -0,0 +1,177 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Store presents a directory tree of mbox files as a hierarchy of mail
+// folders.  Each folder is named by its path relative to the store's root,
+// with slashes separating levels, as in "Lists/golang-nuts".
+//
+// Any regular file which CreateMboxStream accepts is a folder, as is any empty
+// file, since that's how a mailbox with no messages looks.  Files and
+// directories whose names begin with a dot are ignored, which excludes the
+// lock and temporary files this package creates.
+type Store struct {
+	root string
+}
+
+// A Folder is a single mailbox within a Store.
+type Folder struct {
+	// Name identifies the folder within its store.
+	Name string
+
+	// Path locates the folder's mbox file.
+	Path string
+}
+
+// OpenStore opens the store rooted at the given directory.
+func OpenStore(root string) (*Store, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &Store{root}, nil
+}
+
+// Root answers the store's root directory.
+func (s *Store) Root() string {
+	return s.root
+}
+
+// Folders walks the store and answers every folder in it, sorted by name.
+func (s *Store) Folders() ([]*Folder, error) {
+	var folders []*Folder
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.root {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !isMboxFile(p) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		folders = append(folders, &Folder{Name: filepath.ToSlash(rel), Path: p})
+		return nil
+	})
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	return folders, err
+}
+
+// Folder answers the named folder, or an error if it doesn't exist or isn't
+// an mbox file.
+func (s *Store) Folder(name string) (*Folder, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() || !isMboxFile(p) {
+		return nil, fmt.Errorf("%s is not a mailbox", name)
+	}
+	return &Folder{Name: path.Clean(name), Path: p}, nil
+}
+
+// CreateFolder creates a new, empty folder, along with any directories needed
+// to hold it.  It fails if the folder already exists.
+func (s *Store) CreateFolder(name string) (*Folder, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	return &Folder{Name: path.Clean(name), Path: p}, nil
+}
+
+// path converts a folder name to the path of its file, refusing names which
+// would escape the store or be ignored by Folders.
+func (s *Store) path(name string) (string, error) {
+	clean := path.Clean(name)
+	if name == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("Invalid folder name %q", name)
+	}
+	for _, part := range strings.Split(clean, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", fmt.Errorf("Invalid folder name %q", name)
+		}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// isMboxFile answers true if the named file is empty, or starts with a From
+// marker that CreateMboxStream accepts.  The marker is checked first, so large
+// files of other kinds aren't read in search of a line ending.
+func isMboxFile(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	marker := make([]byte, len(fromMarker))
+	switch n, _ := io.ReadFull(f, marker); {
+	case n == 0:
+		return true
+	case !isFromLine(marker[:n]):
+		return false
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	_, err = CreateMboxStream(f)
+	return err == nil
+}
+
+// Count answers the number of messages in the folder.
+func (f *Folder) Count() (int, error) {
+	mm, err := OpenMapped(f.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer mm.Close()
+	return mm.Len(), nil
+}
+
+// Open maps the folder into memory for reading, as OpenMapped does.
+func (f *Folder) Open() (*MappedMbox, error) {
+	return OpenMapped(f.Path)
+}
+
+// Append adds a message to the folder, locking it as Append does.
+func (f *Folder) Append(env Envelope, content io.Reader) error {
+	return Append(f.Path, env, content)
+}
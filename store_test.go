@@ -0,0 +1,98 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeStore lays out a directory tree holding mailboxes and other files.
+func makeStore(t *testing.T) *Store {
+	root := t.TempDir()
+	files := map[string]string{
+		"INBOX":                mboxWith3Messages,
+		"Lists/golang":         mboxToSplit,
+		"Lists/empty":          "",
+		"Lists/notes.txt":      "Not a mailbox.\n",
+		"Lists/.golang.lock":   "",
+		".hidden/INBOX":        mboxWith3Messages,
+		"Archive/2006/January": mboxWith3Messages,
+	}
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := OpenStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Given a directory tree of mailboxes and other files
+// When I list its folders
+// Then I expect only the mailboxes, with their message counts.
+func TestStore10(t *testing.T) {
+	s := makeStore(t)
+	folders, err := s.Folders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range folders {
+		n, err := f.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s=%d", f.Name, n))
+	}
+	expected := "Archive/2006/January=3,INBOX=3,Lists/empty=0,Lists/golang=3"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Expected %s; got %s", expected, strings.Join(got, ","))
+	}
+}
+
+// Given a store
+// When I create a folder and append to it
+// Then I expect to find the folder and its message.
+func TestStore20(t *testing.T) {
+	s := makeStore(t)
+	f, err := s.CreateFolder("Lists/new/folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateFolder("Lists/new/folder"); err == nil {
+		t.Error("Creating an existing folder should fail")
+	}
+	if err := f.Append(Envelope{Sender: "foo@bar.com"}, strings.NewReader("Subject: Hi\n\nHello.\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = s.Folder("Lists/new/folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mm.Close()
+	if mm.Len() != 1 || string(mm.Message(0).Get("Subject")) != "Hi" {
+		t.Error("Appended message not found")
+	}
+
+	for _, name := range []string{"../escape", "/etc/passwd", "Lists/.golang.lock", "Lists/notes.txt", "Lists"} {
+		if _, err := s.Folder(name); err == nil {
+			t.Error("Expected an error for ", name)
+		}
+	}
+}
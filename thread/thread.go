@@ -0,0 +1,362 @@
+// vim: ts=8 noexpandtab ai
+
+// Package thread arranges messages into conversation threads using Jamie
+// Zawinski's algorithm, as described at https://www.jwz.org/doc/threading.html.
+//
+// Messages are linked by their Message-ID, In-Reply-To and References
+// headers.  Where a message refers to a parent which isn't present, a dummy
+// container stands in for it, so the shape of the conversation is preserved.
+// Finally, threads whose roots share a subject are gathered together, catching
+// replies from mail clients which don't send references.
+package thread
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// A Summary holds what threading needs to know about a message, along with
+// enough to identify and display it.
+type Summary struct {
+	MessageID  string
+	References []string
+	Subject    string
+	From       string
+	Date       time.Time
+	Offset     int64
+}
+
+// A Container holds one node of a thread.  Dummy containers, standing in for
+// messages referred to but not present, have a nil Message.
+type Container struct {
+	Message  *Summary
+	Parent   *Container
+	Children []*Container
+}
+
+// IsDummy answers true if the container holds no message.
+func (c *Container) IsDummy() bool {
+	return c.Message == nil
+}
+
+// Walk calls fn for the container and each of its descendants, depth first,
+// along with their depth relative to c.
+func (c *Container) Walk(fn func(c *Container, depth int)) {
+	c.walk(fn, 0)
+}
+
+func (c *Container) walk(fn func(*Container, int), depth int) {
+	fn(c, depth)
+	for _, k := range c.Children {
+		k.walk(fn, depth+1)
+	}
+}
+
+// earliest answers the earliest date of any message in the thread.
+func (c *Container) earliest() (t time.Time) {
+	c.Walk(func(k *Container, _ int) {
+		if k.Message != nil && !k.Message.Date.IsZero() && (t.IsZero() || k.Message.Date.Before(t)) {
+			t = k.Message.Date
+		}
+	})
+	return
+}
+
+// subject answers the subject of the container's message, or, for a dummy,
+// that of its first child.
+func (c *Container) subject() string {
+	if c.Message != nil {
+		return c.Message.Subject
+	}
+	if len(c.Children) > 0 && c.Children[0].Message != nil {
+		return c.Children[0].Message.Subject
+	}
+	return ""
+}
+
+// hasDescendant answers true if d is c or lies beneath it.
+func (c *Container) hasDescendant(d *Container) bool {
+	for ; d != nil; d = d.Parent {
+		if d == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Container) addChild(k *Container) {
+	if k.Parent != nil {
+		k.Parent.removeChild(k)
+	}
+	k.Parent = c
+	c.Children = append(c.Children, k)
+}
+
+func (c *Container) removeChild(k *Container) {
+	for i, x := range c.Children {
+		if x == k {
+			c.Children = append(c.Children[:i], c.Children[i+1:]...)
+			break
+		}
+	}
+	k.Parent = nil
+}
+
+// Summarize extracts a Summary from a message's headers.
+func Summarize(msg *mbox.Message) *Summary {
+	h := msg.MailHeader()
+	s := &Summary{
+		MessageID:  msg.MessageID(),
+		References: messageIDs(h.Get("References")),
+		Subject:    mbox.DecodeHeader(h.Get("Subject")),
+		From:       mbox.DecodeHeader(h.Get("From")),
+		Offset:     msg.Offset(),
+	}
+	s.Date, _ = h.Date()
+
+	// In-Reply-To names the immediate parent; append it unless References
+	// already ends with it.
+	if irt := messageIDs(h.Get("In-Reply-To")); len(irt) > 0 {
+		if n := len(s.References); n == 0 || s.References[n-1] != irt[0] {
+			s.References = append(s.References, irt[0])
+		}
+	}
+	return s
+}
+
+var messageIDPattern = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// messageIDs extracts the angle-bracketed message identifiers from a header.
+func messageIDs(v string) (ids []string) {
+	for _, m := range messageIDPattern.FindAllStringSubmatch(v, -1) {
+		ids = append(ids, m[1])
+	}
+	return
+}
+
+// A Threader accumulates messages and arranges them into threads.
+type Threader struct {
+	ids     map[string]*Container
+	order   []*Container
+	counter int
+}
+
+// New creates an empty Threader.
+func New() *Threader {
+	return &Threader{ids: make(map[string]*Container)}
+}
+
+// Add links a message into the threads built so far.
+func (t *Threader) Add(s *Summary) {
+	id := s.MessageID
+	c := t.ids[id]
+	if id == "" || (c != nil && c.Message != nil) {
+		// Missing or duplicate identifiers would confuse the linkage;
+		// give the message one of its own.
+		t.counter++
+		id = fmt.Sprintf("\x00%d", t.counter)
+		c = nil
+	}
+	if c == nil {
+		c = t.container(id)
+	}
+	c.Message = s
+
+	// Link each reference to the next, unless they are linked already.
+	var prev *Container
+	for _, ref := range s.References {
+		r := t.container(ref)
+		if prev != nil && r.Parent == nil && !r.hasDescendant(prev) {
+			prev.addChild(r)
+		}
+		prev = r
+	}
+
+	// The last reference is this message's parent, overriding any guess
+	// made earlier from another message's references.
+	if c.Parent != nil {
+		c.Parent.removeChild(c)
+	}
+	if prev != nil && !c.hasDescendant(prev) {
+		prev.addChild(c)
+	}
+}
+
+func (t *Threader) container(id string) *Container {
+	c := t.ids[id]
+	if c == nil {
+		c = &Container{}
+		t.ids[id] = c
+		t.order = append(t.order, c)
+	}
+	return c
+}
+
+// Threads answers the roots of the thread forest, ordered by the date of each
+// thread's earliest message.  Children are likewise ordered by date.
+//
+// Pruning and grouping work on a fresh copy of the containers, so the Threader
+// itself is left untouched: Threads may be called any number of times, with
+// or without further calls to Add in between.
+func (t *Threader) Threads() []*Container {
+	copies := make(map[*Container]*Container, len(t.order))
+	for _, c := range t.order {
+		copies[c] = &Container{Message: c.Message}
+	}
+
+	var roots []*Container
+	for _, c := range t.order {
+		k := copies[c]
+		if c.Parent == nil {
+			roots = append(roots, k)
+		} else {
+			k.Parent = copies[c.Parent]
+		}
+		for _, child := range c.Children {
+			k.Children = append(k.Children, copies[child])
+		}
+	}
+
+	roots = prune(roots, nil)
+	roots = groupBySubject(roots)
+
+	for _, r := range roots {
+		r.Walk(func(c *Container, _ int) {
+			sortByDate(c.Children)
+		})
+	}
+	sortByDate(roots)
+	return roots
+}
+
+// prune removes dummy containers which serve no purpose: those with no
+// children are dropped, and those with children are replaced by them, except
+// at the root, where a dummy may hold several children together.
+func prune(cs []*Container, parent *Container) []*Container {
+	var out []*Container
+	for _, c := range cs {
+		c.Children = prune(c.Children, c)
+		switch {
+		case c.IsDummy() && len(c.Children) == 0:
+			continue
+		case c.IsDummy() && (parent != nil || len(c.Children) == 1):
+			for _, k := range c.Children {
+				k.Parent = parent
+			}
+			out = append(out, c.Children...)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// groupBySubject gathers root threads which share a base subject.
+func groupBySubject(roots []*Container) []*Container {
+	table := make(map[string]*Container)
+	for _, c := range roots {
+		subj := baseSubject(c.subject())
+		if subj == "" {
+			continue
+		}
+		old := table[subj]
+		if old == nil ||
+			(c.IsDummy() && !old.IsDummy()) ||
+			(old.Message != nil && isReply(old.Message.Subject) && c.Message != nil && !isReply(c.Message.Subject)) {
+			table[subj] = c
+		}
+	}
+
+	for _, c := range roots {
+		subj := baseSubject(c.subject())
+		t := table[subj]
+		if t == nil || t == c {
+			continue
+		}
+
+		switch {
+		case t.IsDummy() && c.IsDummy():
+			for len(c.Children) > 0 {
+				t.addChild(c.Children[0])
+			}
+		case t.IsDummy():
+			t.addChild(c)
+		case !isReply(t.Message.Subject) && c.Message != nil && isReply(c.Message.Subject):
+			t.addChild(c)
+		default:
+			// Neither is clearly the parent of the other, so make
+			// them siblings beneath a new dummy.
+			d := &Container{}
+			d.addChild(t)
+			d.addChild(c)
+			table[subj] = d
+		}
+	}
+
+	// Merging may have moved roots beneath others, or emptied dummies.
+	// Whatever now sits at the top of each original root's thread is a
+	// root.
+	var out []*Container
+	seen := make(map[*Container]bool)
+	for _, c := range roots {
+		for c.Parent != nil {
+			c = c.Parent
+		}
+		if seen[c] || (c.IsDummy() && len(c.Children) == 0) {
+			continue
+		}
+		seen[c] = true
+		out = append(out, c)
+	}
+	return out
+}
+
+var replyPrefix = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv)(\[\d+\])?:|\[[^\]]*\])\s*`)
+
+// baseSubject strips reply and forwarding prefixes, and mailing list tags,
+// from a subject.
+func baseSubject(s string) string {
+	for {
+		t := replyPrefix.ReplaceAllString(s, "")
+		if t == s {
+			return strings.ToLower(strings.TrimSpace(s))
+		}
+		s = t
+	}
+}
+
+// isReply answers true if the subject bears a reply or forwarding prefix.
+func isReply(s string) bool {
+	return baseSubject(s) != strings.ToLower(strings.TrimSpace(s))
+}
+
+func sortByDate(cs []*Container) {
+	sort.SliceStable(cs, func(i, j int) bool {
+		return cs[i].earliest().Before(cs[j].earliest())
+	})
+}
+
+// FromStream threads every remaining message in the stream.  Only headers are
+// examined; bodies are skipped.
+func FromStream(s *mbox.MboxStream) ([]*Container, error) {
+	t := New()
+	for {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			return t.Threads(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.Add(Summarize(msg))
+		if _, err := io.Copy(io.Discard, msg.BodyReader()); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// vim: ts=8 noexpandtab ai
+
+package thread
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sam-falvo/mbox"
+)
+
+const mailbox = `From a@example.com
+Message-ID: <1@example.com>
+Subject: Picnic
+Date: Mon, 2 Jan 2006 10:00:00 +0000
+
+Saturday?
+
+From b@example.com
+Message-ID: <3@example.com>
+In-Reply-To: <2@example.com>
+References: <1@example.com> <2@example.com>
+Subject: Re: Picnic
+Date: Mon, 2 Jan 2006 12:00:00 +0000
+
+Replying to a message we never received.
+
+From c@example.com
+Message-ID: <4@example.com>
+Subject: Re: Picnic
+Date: Mon, 2 Jan 2006 13:00:00 +0000
+
+A client which sends no references.
+
+From d@example.com
+Message-ID: <5@example.com>
+Subject: Unrelated
+Date: Sun, 1 Jan 2006 09:00:00 +0000
+
+Something else entirely.
+
+From e@example.com
+Message-ID: <6@example.com>
+References: <9@example.com>
+Subject: Re: Budget
+Date: Tue, 3 Jan 2006 09:00:00 +0000
+
+First reply to a message we never received.
+
+From f@example.com
+Message-ID: <7@example.com>
+In-Reply-To: <9@example.com>
+Subject: Re: Budget
+Date: Tue, 3 Jan 2006 10:00:00 +0000
+
+Second reply to it.
+`
+
+// render draws the thread forest as text, one container per line, indented by
+// depth.  Dummies appear as "*".
+func render(roots []*Container) string {
+	var b strings.Builder
+	for _, r := range roots {
+		r.Walk(func(c *Container, depth int) {
+			id := "*"
+			if c.Message != nil {
+				id = c.Message.MessageID
+			}
+			fmt.Fprintf(&b, "%s%s\n", strings.Repeat("  ", depth), id)
+		})
+	}
+	return b.String()
+}
+
+// Given messages with missing parents and missing references
+// When I thread them
+// Then I expect dummies for missing parents and subject-based grouping.
+func TestThread10(t *testing.T) {
+	s, err := mbox.CreateMboxStream(strings.NewReader(mailbox))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := FromStream(s)
+	if err != nil {
+		t.Fatal("TestThread10: ", err)
+	}
+	expected := "5@example.com\n" +
+		"1@example.com\n" +
+		"  3@example.com\n" +
+		"  4@example.com\n" +
+		"*\n" +
+		"  6@example.com\n" +
+		"  7@example.com\n"
+	if got := render(roots); got != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+// Given messages whose references would form a loop
+// When I thread them
+// Then I expect the loop to be broken.
+func TestThread20(t *testing.T) {
+	th := New()
+	th.Add(&Summary{MessageID: "a", References: []string{"b"}})
+	th.Add(&Summary{MessageID: "b", References: []string{"a"}})
+	roots := th.Threads()
+	if len(roots) != 1 {
+		t.Fatalf("Expected a single thread; got\n%s", render(roots))
+	}
+}
+
+// Given a threader holding messages which need pruning and grouping
+// When I ask for its threads twice, adding a message in between
+// Then I expect each answer to reflect every message added so far.
+func TestThread30(t *testing.T) {
+	th := New()
+	th.Add(&Summary{MessageID: "1", Subject: "Picnic"})
+	th.Add(&Summary{MessageID: "3", Subject: "Re: Picnic", References: []string{"1", "2"}})
+	th.Add(&Summary{MessageID: "4", Subject: "Re: Picnic"})
+	th.Add(&Summary{MessageID: "6", Subject: "Re: Budget", References: []string{"9"}})
+	th.Add(&Summary{MessageID: "7", Subject: "Re: Budget", References: []string{"9"}})
+	th.Add(&Summary{MessageID: "10", Subject: "Minutes"})
+	th.Add(&Summary{MessageID: "11", Subject: "Minutes"})
+
+	first := render(th.Threads())
+	if second := render(th.Threads()); second != first {
+		t.Fatalf("Expected\n%s\ngot\n%s", first, second)
+	}
+
+	th.Add(&Summary{MessageID: "8", Subject: "Re: Picnic", References: []string{"3"}})
+	expected := "1\n" +
+		"  3\n" +
+		"    8\n" +
+		"  4\n" +
+		"*\n" +
+		"  6\n" +
+		"  7\n" +
+		"*\n" +
+		"  10\n" +
+		"  11\n"
+	if got := render(th.Threads()); got != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+// Given a variety of subjects
+// When I reduce them to base subjects
+// Then I expect prefixes and list tags to be removed.
+func TestBaseSubject10(t *testing.T) {
+	for in, out := range map[string]string{
+		"Re: Picnic":             "picnic",
+		"RE: Fwd: [list] Picnic": "picnic",
+		"Re[2]: Picnic":          "picnic",
+		"Picnic: the sequel":     "picnic: the sequel",
+	} {
+		if got := baseSubject(in); got != out {
+			t.Errorf("%q: expected %q; got %q", in, out, got)
+		}
+	}
+}
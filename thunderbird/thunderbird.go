@@ -0,0 +1,295 @@
+// vim: ts=8 noexpandtab ai
+
+// Package thunderbird reads the mail folders of a Thunderbird profile.
+//
+// Thunderbird keeps each account's folders in a directory such as
+// Mail/Local Folders or ImapMail/imap.example.com; OpenProfile finds them
+// all, and OpenAccount reads a single one.  Each folder is an mbox file,
+// accompanied by a .msf summary file holding Thunderbird's index in Mork
+// format; subfolders of a folder named Inbox live in a directory named
+// Inbox.sbd alongside it.  Summary files are recognized but not parsed: the
+// mbox file is authoritative, and Thunderbird rebuilds summaries from it.
+//
+// Deleting a message in Thunderbird doesn't remove it from the mbox file
+// until the folder is compacted.  Instead, it sets the expunged flag in the
+// message's X-Mozilla-Status header.  A Reader skips such messages.
+package thunderbird
+
+import (
+	"io"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sam-falvo/mbox"
+)
+
+// Flags recorded in the X-Mozilla-Status header.
+const (
+	Read      = 0x0001
+	Replied   = 0x0002
+	Marked    = 0x0004
+	Expunged  = 0x0008
+	HasRe     = 0x0010
+	Elided    = 0x0020
+	Offline   = 0x0080
+	Watched   = 0x0100
+	Attached  = 0x0400
+	Queued    = 0x0800
+	Forwarded = 0x1000
+)
+
+// Status answers the flags recorded in the header's X-Mozilla-Status field,
+// which holds four hexadecimal digits.  The second result is false if the
+// field is missing or malformed.
+func Status(h mail.Header) (uint16, bool) {
+	n, err := strconv.ParseUint(strings.TrimSpace(h.Get("X-Mozilla-Status")), 16, 16)
+	return uint16(n), err == nil
+}
+
+// IsExpunged answers true if the header marks the message as deleted.
+func IsExpunged(h mail.Header) bool {
+	status, ok := Status(h)
+	return ok && status&Expunged != 0
+}
+
+// Keys answers the tags recorded in the header's X-Mozilla-Keys field, such
+// as "$label1" or "junk".
+func Keys(h mail.Header) []string {
+	return strings.Fields(h.Get("X-Mozilla-Keys"))
+}
+
+// A Folder describes one mail folder of a Thunderbird account.
+type Folder struct {
+	// Name holds the folder's own name, such as "Projects".
+	Name string
+
+	// Path names the folder within the account, with levels separated by
+	// slashes, such as "Inbox/Projects".
+	Path string
+
+	// File locates the folder's mbox file.  It is empty for folders which
+	// exist only to hold subfolders.
+	File string
+
+	// Summary locates the folder's .msf summary file, if it has one.
+	Summary string
+
+	// Children lists the folder's subfolders, sorted by name.
+	Children []*Folder
+}
+
+// nonFolderSuffixes lists the extensions of files Thunderbird keeps in mail
+// directories alongside the folders themselves.
+var nonFolderSuffixes = []string{".msf", ".sbd", ".dat", ".json", ".html", ".sqlite", ".mab", ".js", ".bak", ".log"}
+
+// OpenAccount enumerates the folder hierarchy found in an account's mail
+// directory, answering its top-level folders sorted by name.
+func OpenAccount(dir string) ([]*Folder, error) {
+	return readFolders(dir, "")
+}
+
+// An Account holds the folders of one account in a Thunderbird profile.
+type Account struct {
+	// Name holds the name of the account's directory, such as "Local
+	// Folders" or "imap.example.com".
+	Name string
+
+	// Dir locates the account's mail directory.
+	Dir string
+
+	// Folders lists the account's top-level folders, as OpenAccount
+	// answers them.
+	Folders []*Folder
+}
+
+// accountParents names the profile directories holding accounts' mail
+// directories: Mail for local folders and POP accounts, and ImapMail for IMAP
+// accounts.
+var accountParents = []string{"Mail", "ImapMail"}
+
+// OpenProfile enumerates the accounts of a Thunderbird profile and their
+// folders.  The directory given may be the profile itself, in which case the
+// accounts under both its Mail and ImapMail directories are answered, or
+// either of those directories alone.  Accounts are sorted by directory.
+func OpenProfile(dir string) ([]*Account, error) {
+	var parents []string
+	for _, name := range accountParents {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil && fi.IsDir() {
+			parents = append(parents, filepath.Join(dir, name))
+		}
+	}
+	if parents == nil {
+		parents = []string{dir}
+	}
+
+	var accounts []*Account
+	for _, parent := range parents {
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() || strings.HasPrefix(e.Name(), ".") || strings.HasSuffix(e.Name(), ".sbd") {
+				continue
+			}
+			a := &Account{Name: e.Name(), Dir: filepath.Join(parent, e.Name())}
+			if a.Folders, err = OpenAccount(a.Dir); err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+func readFolders(dir, parent string) ([]*Folder, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool)
+	for _, e := range entries {
+		present[e.Name()] = true
+	}
+
+	byName := make(map[string]*Folder)
+	folder := func(name string) *Folder {
+		f, ok := byName[name]
+		if !ok {
+			f = &Folder{Name: name, Path: path.Join(parent, name)}
+			byName[name] = f
+		}
+		return f
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		full := filepath.Join(dir, name)
+		switch {
+		case e.IsDir() && strings.HasSuffix(name, ".sbd"):
+			f := folder(strings.TrimSuffix(name, ".sbd"))
+			if f.Children, err = readFolders(full, f.Path); err != nil {
+				return nil, err
+			}
+		case e.IsDir() || hasSuffix(name, nonFolderSuffixes) || strings.HasPrefix(name, "."):
+		case present[name+".msf"] || present[name+".sbd"] || looksLikeMbox(full):
+			f := folder(name)
+			f.File = full
+			if present[name+".msf"] {
+				f.Summary = full + ".msf"
+			}
+		}
+	}
+
+	folders := make([]*Folder, 0, len(byName))
+	for _, f := range byName {
+		folders = append(folders, f)
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	return folders, nil
+}
+
+func hasSuffix(name string, suffixes []string) bool {
+	for _, s := range suffixes {
+		if strings.HasSuffix(strings.ToLower(name), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeMbox answers true if the file is empty, as Thunderbird leaves
+// newly created folders, or starts with a From marker.
+func looksLikeMbox(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, 5)
+	n, _ := io.ReadFull(f, buf)
+	return n == 0 || n == 5 && string(buf) == "From "
+}
+
+// Walk calls fn for each folder, and then each of its subfolders, depth
+// first.  It stops at the first error fn returns.
+func Walk(folders []*Folder, fn func(*Folder) error) error {
+	for _, f := range folders {
+		if err := fn(f); err != nil {
+			return err
+		}
+		if err := Walk(f.Children, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A Reader reads the messages of a single folder.
+type Reader struct {
+	// IncludeExpunged, if set, makes ReadMessage return messages which
+	// were deleted but not yet compacted away.
+	IncludeExpunged bool
+
+	f *os.File
+	s *mbox.MboxStream
+}
+
+// Open prepares to read the folder's messages.  Folders without a file, and
+// empty ones, simply have no messages.
+func (f *Folder) Open() (*Reader, error) {
+	r := &Reader{}
+	if f.File == "" {
+		return r, nil
+	}
+	var err error
+	if r.f, err = os.Open(f.File); err != nil {
+		return nil, err
+	}
+	r.s, err = mbox.CreateMboxStream(r.f)
+	if err == io.EOF {
+		r.s, err = nil, nil
+	}
+	if err != nil {
+		r.f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReadMessage answers the folder's next message, skipping those marked as
+// expunged unless IncludeExpunged is set.  It answers io.EOF once no messages
+// remain.  As with MboxStream, each message's body must be read in full before
+// calling ReadMessage again.
+func (r *Reader) ReadMessage() (*mbox.Message, error) {
+	if r.s == nil {
+		return nil, io.EOF
+	}
+	for {
+		msg, err := r.s.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if r.IncludeExpunged || !IsExpunged(msg.MailHeader()) {
+			return msg, nil
+		}
+		if _, err = io.Copy(io.Discard, msg.BodyReader()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close releases the folder's file.
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
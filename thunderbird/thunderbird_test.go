@@ -0,0 +1,189 @@
+// vim: ts=8 noexpandtab ai
+
+package thunderbird
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const inbox = `From - Mon Jan  2 15:04:05 2006
+X-Mozilla-Status: 0001
+X-Mozilla-Keys: $label1 junk
+Subject: Kept
+
+Still here.
+
+From - Mon Jan  2 15:04:06 2006
+X-Mozilla-Status: 0009
+Subject: Deleted
+
+Awaiting compaction.
+
+From - Mon Jan  2 15:04:07 2006
+Subject: Unmarked
+
+No status at all.
+`
+
+// makeAccount lays out a small Thunderbird mail directory.
+func makeAccount(t *testing.T) string {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Inbox":                 inbox,
+		"Inbox.msf":             "// <!-- <mdb:mork:z v=\"1.4\"/> -->",
+		"Inbox.sbd/Projects":    "From - Mon Jan  2 15:04:05 2006\nSubject: Plans\n\nSoon.\n",
+		"Trash":                 "",
+		"Trash.msf":             "",
+		"Archives.sbd/2006":     "",
+		"Archives.sbd/2006.msf": "",
+		"msgFilterRules.dat":    "version=\"9\"\n",
+		"popstate.dat":          "",
+		"Inbox.sbd/notes.txt":   "Not a mailbox.\n",
+	})
+	return dir
+}
+
+// writeFiles creates the files given beneath dir, with their directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		full := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Given a Thunderbird profile with a local and an IMAP account
+// When I open the profile, and then its Mail directory alone
+// Then I expect every account's folders, and only local ones respectively.
+func TestOpenProfile10(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"prefs.js":                                     "",
+		"Mail/Local Folders/Inbox":                     inbox,
+		"Mail/Local Folders/Inbox.msf":                 "",
+		"Mail/Local Folders/Inbox.sbd/Projects":        "",
+		"Mail/Local Folders/Trash":                     "",
+		"Mail/pop.example.com/Inbox":                   "",
+		"ImapMail/imap.example.com/INBOX":              "",
+		"ImapMail/imap.example.com/msgFilterRules.dat": "",
+	})
+
+	describe := func(accounts []*Account) string {
+		var descs []string
+		for _, a := range accounts {
+			Walk(a.Folders, func(f *Folder) error {
+				descs = append(descs, a.Name+":"+f.Path)
+				return nil
+			})
+		}
+		return strings.Join(descs, ",")
+	}
+
+	accounts, err := OpenProfile(dir)
+	if err != nil {
+		t.Fatal("TestOpenProfile10: ", err)
+	}
+	expected := "Local Folders:Inbox,Local Folders:Inbox/Projects,Local Folders:Trash,pop.example.com:Inbox,imap.example.com:INBOX"
+	if got := describe(accounts); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+
+	accounts, err = OpenProfile(filepath.Join(dir, "Mail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "Local Folders:Inbox,Local Folders:Inbox/Projects,Local Folders:Trash,pop.example.com:Inbox"
+	if got := describe(accounts); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+}
+
+// Given a Thunderbird mail directory
+// When I open it
+// Then I expect its folder hierarchy, and nothing else.
+func TestOpenAccount10(t *testing.T) {
+	dir := makeAccount(t)
+	folders, err := OpenAccount(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	Walk(folders, func(f *Folder) error {
+		desc := f.Path
+		if f.File == "" {
+			desc += "(container)"
+		}
+		if f.Summary != "" {
+			desc += "(msf)"
+		}
+		paths = append(paths, desc)
+		return nil
+	})
+	expected := "Archives(container),Archives/2006(msf),Inbox(msf),Inbox/Projects,Trash(msf)"
+	if got := strings.Join(paths, ","); got != expected {
+		t.Errorf("Expected %s; got %s", expected, got)
+	}
+}
+
+// Given a folder holding an expunged message
+// When I read it
+// Then I expect the expunged message skipped, unless I ask for it.
+func TestReader10(t *testing.T) {
+	f := &Folder{File: filepath.Join(makeAccount(t), "Inbox")}
+	for _, include := range []bool{false, true} {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.IncludeExpunged = include
+		var subjects []string
+		for {
+			msg, err := r.ReadMessage()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			subjects = append(subjects, msg.MailHeader().Get("Subject"))
+			io.Copy(io.Discard, msg.BodyReader())
+			if keys := Keys(msg.MailHeader()); len(subjects) == 1 && strings.Join(keys, " ") != "$label1 junk" {
+				t.Error("Keys wrong: ", keys)
+			}
+		}
+		r.Close()
+
+		expected := "Kept,Unmarked"
+		if include {
+			expected = "Kept,Deleted,Unmarked"
+		}
+		if got := strings.Join(subjects, ","); got != expected {
+			t.Errorf("Expected %s; got %s", expected, got)
+		}
+	}
+}
+
+// Given an empty folder, or one existing only to hold subfolders
+// When I read it
+// Then I expect no messages.
+func TestReader20(t *testing.T) {
+	dir := makeAccount(t)
+	for _, f := range []*Folder{{File: filepath.Join(dir, "Trash")}, {}} {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.ReadMessage(); err != io.EOF {
+			t.Error("Expected io.EOF; got ", err)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,248 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// MaxLineLength is the longest line, excluding its terminator, which RFC 5322
+// permits.
+const MaxLineLength = 998
+
+// A ProblemKind classifies a defect found by Validate.
+type ProblemKind int
+
+const (
+	// UnescapedFrom marks a From line which is probably part of a message
+	// body rather than the start of a new message.
+	UnescapedFrom ProblemKind = iota
+
+	// ContentLengthMismatch marks a Content-Length header which doesn't
+	// agree with the length of the body that follows it.
+	ContentLengthMismatch
+
+	// OverlongLine marks a line longer than MaxLineLength bytes.
+	OverlongLine
+
+	// MalformedHeader marks a line in a header block which is neither a
+	// field nor the continuation of one.
+	MalformedHeader
+
+	// MissingBlankLine marks a message which isn't separated from the one
+	// before it by a blank line.
+	MissingBlankLine
+
+	// CRLFLineEnding marks a message whose lines end in CRLF rather than
+	// LF.  It is reported once per message.
+	CRLFLineEnding
+
+	// MissingFinalNewline marks a mailbox whose last line is unterminated.
+	MissingFinalNewline
+)
+
+var problemKindNames = []string{
+	"unescaped-from",
+	"content-length-mismatch",
+	"overlong-line",
+	"malformed-header",
+	"missing-blank-line",
+	"crlf-line-ending",
+	"missing-final-newline",
+}
+
+var problemKindSeverities = []Severity{
+	Error,
+	Error,
+	Warning,
+	Error,
+	Warning,
+	Warning,
+	Warning,
+}
+
+func (k ProblemKind) String() string {
+	if k < 0 || int(k) >= len(problemKindNames) {
+		return fmt.Sprintf("ProblemKind(%d)", int(k))
+	}
+	return problemKindNames[k]
+}
+
+// Severity answers how seriously problems of this kind should be taken.
+func (k ProblemKind) Severity() Severity {
+	if k < 0 || int(k) >= len(problemKindSeverities) {
+		return Error
+	}
+	return problemKindSeverities[k]
+}
+
+// A Severity ranks problems by the harm they do.
+type Severity int
+
+const (
+	// Warning marks a departure from convention which most readers
+	// tolerate.
+	Warning Severity = iota
+
+	// Error marks a defect which is likely to make readers disagree about
+	// where messages begin and end, or what their headers say.
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// A Problem describes a single defect found by Validate.
+type Problem struct {
+	// Line counts from 1 at the start of the mailbox.
+	Line int
+
+	// Offset locates the start of the offending line.
+	Offset int64
+
+	Kind     ProblemKind
+	Severity Severity
+	Detail   string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d: %s: %s: %s", p.Line, p.Severity, p.Kind, p.Detail)
+}
+
+// A reporter delivers a problem found at the given line and offset.
+type reporter func(line int, offset int64, kind ProblemKind, detail string)
+
+// Validate examines a whole mailbox held in memory, calling fn for each
+// problem it finds, in the order they occur.  Messages are delimited as the
+// Scanner does.  Validate answers ErrNotMbox if the buffer isn't an mbox file
+// at all; the problems it reports never stop it.  The mailbox's dialect is
+// detected as by DetectDialect.
+func Validate(buf []byte, fn func(Problem)) error {
+	d, _ := DetectDialect(bytes.NewReader(buf))
+	return ValidateDialect(buf, d, fn)
+}
+
+// ValidateDialect works like Validate, but for a mailbox known to be in the
+// given dialect.  A From line inside a body delimited by Content-Length is
+// only a problem for dialects which rely on escaping such lines instead.
+func ValidateDialect(buf []byte, d Dialect, fn func(Problem)) error {
+	report := func(line int, offset int64, kind ProblemKind, detail string) {
+		fn(Problem{line, offset, kind, kind.Severity(), detail})
+	}
+
+	s := NewScanner(buf)
+	line := 1
+	var prev []byte
+	for s.Next() {
+		v := s.View()
+		if prev != nil && !endsWithBlankLine(prev) {
+			if isStrayFrom(v) {
+				report(line, v.Offset, UnescapedFrom, "From line neither preceded by a blank line nor followed by a header")
+			} else {
+				report(line, v.Offset, MissingBlankLine, "no blank line before this message")
+			}
+		}
+		validateView(v, line, d, report)
+		line += bytes.Count(v.Raw, []byte("\n"))
+		prev = v.Raw
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if n := len(buf); n > 0 && buf[n-1] != '\n' {
+		report(line, int64(bytes.LastIndexByte(buf, '\n')+1), MissingFinalNewline, "last line has no terminator")
+	}
+	return nil
+}
+
+// isStrayFrom answers true if a message found by the Scanner looks more like
+// an unescaped body line than a genuine message: it has no header at all, or
+// its first header line is malformed.  This is only suspicious where the
+// message before it doesn't end in a blank line.
+func isStrayFrom(v *View) bool {
+	return len(v.Header) == 0 || checkField(trimEOL(v.Header[:lineEnd(v.Header, 0)])) != ""
+}
+
+// validateView checks a single message which begins on the given line.
+func validateView(v *View, line int, d Dialect, report reporter) {
+	raw := v.Raw
+	headerStart := lineEnd(raw, 0)
+	headerEnd := headerStart + len(v.Header)
+	bodyStart := len(raw) - len(v.Body)
+	seenLength, crlf := false, false
+	for p := 0; p < len(raw); line++ {
+		e := lineEnd(raw, p)
+		l := raw[p:e]
+		at := v.Offset + int64(p)
+		if !crlf && bytes.HasSuffix(l, []byte("\r\n")) {
+			crlf = true
+			report(line, at, CRLFLineEnding, "message uses CRLF line endings")
+		}
+		if n := len(trimEOL(l)); n > MaxLineLength {
+			report(line, at, OverlongLine, fmt.Sprintf("%d bytes long", n))
+		}
+		switch {
+		case p >= bodyStart:
+			if isFromLine(l) && !d.usesContentLength() {
+				report(line, at, UnescapedFrom, "From line inside a body delimited by Content-Length")
+			}
+		case p < headerStart || p >= headerEnd:
+		case isspace(l[0]):
+			if p == headerStart {
+				report(line, at, MalformedHeader, "continuation line with no field to continue")
+			}
+		default:
+			if detail := checkField(trimEOL(l)); detail != "" {
+				report(line, at, MalformedHeader, detail)
+			} else if !seenLength && equalFold(l[:bytes.IndexByte(l, ':')], "Content-Length") {
+				seenLength = true
+				if detail := checkContentLength(v); detail != "" {
+					report(line, at, ContentLengthMismatch, detail)
+				}
+			}
+		}
+		p = e
+	}
+}
+
+// checkField describes what's wrong with the first line of a header field, or
+// answers the empty string if nothing is.
+func checkField(line []byte) string {
+	k := bytes.IndexByte(line, ':')
+	if k < 1 {
+		return "expected a field name followed by a colon"
+	}
+	for _, c := range line[:k] {
+		if c <= ' ' || c > '~' {
+			return fmt.Sprintf("invalid character %q in field name", c)
+		}
+	}
+	return ""
+}
+
+// checkContentLength describes how the message's Content-Length header
+// disagrees with its body, or answers the empty string if it doesn't.  Like
+// the Scanner, it allows for a blank line separating the body from the next
+// message.
+func checkContentLength(v *View) string {
+	value := v.Get("Content-Length")
+	n, ok := parseDecimal(value)
+	if !ok {
+		return fmt.Sprintf("unreadable Content-Length %q", value)
+	}
+	body := v.Body
+	if n == len(body) || n < len(body) && isBlankLine(body[n:]) && lineEnd(body, n) == len(body) {
+		return ""
+	}
+	return fmt.Sprintf("Content-Length is %d but the body holds %d bytes", n, len(body))
+}
+
+// endsWithBlankLine answers true if the last line of a message is blank.
+func endsWithBlankLine(raw []byte) bool {
+	return bytes.HasSuffix(raw, []byte("\n\n")) || bytes.HasSuffix(raw, []byte("\n\r\n"))
+}
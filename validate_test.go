@@ -0,0 +1,174 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// collectProblems validates a mailbox and answers what it found, one problem
+// per string.
+func collectProblems(t *testing.T, s string) []string {
+	var found []string
+	err := Validate([]byte(s), func(p Problem) {
+		found = append(found, p.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+// Given a mailbox which lacks only a blank line between two messages
+// When I validate it
+// Then I expect a single warning.
+func TestValidate10(t *testing.T) {
+	expected := "line 16: warning: missing-blank-line: no blank line before this message"
+	if found := collectProblems(t, mboxWith3Messages); len(found) != 1 || found[0] != expected {
+		t.Error("Expected one warning; got ", found)
+	}
+}
+
+// Given a mailbox with one of each defect
+// When I validate it
+// Then I expect each to be reported on the right line.
+func TestValidate20(t *testing.T) {
+	mailbox := "From a@b.c\n" +
+		"Subject: One\n" +
+		"Not a header\n" +
+		"Content-Length: 99\n" +
+		"\n" +
+		strings.Repeat("x", 1000) + "\n" +
+		"From here on, things get worse.\n" +
+		"\n" +
+		mboxcl2WithUnescapedFrom
+	expected := []string{
+		"line 3: error: malformed-header: expected a field name followed by a colon",
+		"line 4: error: content-length-mismatch: Content-Length is 99 but the body holds 1001 bytes",
+		"line 6: warning: overlong-line: 1000 bytes long",
+		"line 7: error: unescaped-from: From line neither preceded by a blank line nor followed by a header",
+		"line 14: error: unescaped-from: From line inside a body delimited by Content-Length",
+	}
+	found := collectProblems(t, mailbox)
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(found, "\n"))
+	}
+
+	// The Content-Length headers are too unreliable to make it mboxcl2,
+	// but taken as mboxcl2, the From line in a body delimited by
+	// Content-Length is no problem.
+	found = nil
+	ValidateDialect([]byte(mailbox), Mboxcl2, func(p Problem) {
+		found = append(found, p.String())
+	})
+	expected = expected[:len(expected)-1]
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(found, "\n"))
+	}
+}
+
+// Given input which isn't an mbox file
+// When I validate it
+// Then I expect ErrNotMbox.
+func TestValidate30(t *testing.T) {
+	if err := Validate([]byte("Subject: nope\n"), func(Problem) {}); err != ErrNotMbox {
+		t.Error("Expected ErrNotMbox; got ", err)
+	}
+}
+
+// Given a mailbox with every problem Repair can fix
+// When I repair it
+// Then I expect a mailbox which validates cleanly, with nothing lost.
+func TestRepair10(t *testing.T) {
+	mailbox := "From a@b.c\r\n" +
+		"Subject: One\r\n" +
+		"Content-Length: 99\r\n" +
+		"\r\n" +
+		"Hello.\r\n" +
+		"From here on, things get worse.\r\n" +
+		"From d@e.f\n" +
+		"Subject: Two\n" +
+		"\n" +
+		"Goodbye."
+	expected := "From a@b.c\n" +
+		"Subject: One\n" +
+		"Content-Length: 40\n" +
+		"\n" +
+		"Hello.\n" +
+		">From here on, things get worse.\n" +
+		"\n" +
+		"From d@e.f\n" +
+		"Subject: Two\n" +
+		"\n" +
+		"Goodbye.\n" +
+		"\n"
+
+	var out bytes.Buffer
+	var found []string
+	err := Repair(strings.NewReader(mailbox), &out, func(p Problem) {
+		found = append(found, p.Kind.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(found, ",") != "crlf-line-ending,content-length-mismatch,unescaped-from,crlf-line-ending,missing-blank-line,missing-final-newline" {
+		t.Error("Unexpected problems reported: ", found)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected:\n%q\nGot:\n%q", expected, out.String())
+	}
+	if found := collectProblems(t, out.String()); len(found) != 0 {
+		t.Error("Repaired mailbox still has problems: ", found)
+	}
+}
+
+// Given an mboxcl2 mailbox with CRLF line endings and a From line in a body
+// When I repair it
+// Then I expect the message kept whole, unescaped, with its length corrected.
+func TestRepair20(t *testing.T) {
+	body := "Hi.\r\nFrom the top.\r\n"
+	mailbox := "From a@b.c\r\n" +
+		"Subject: One\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		body +
+		"\r\n" +
+		"From d@e.f\r\n" +
+		"Subject: Two\r\n" +
+		"Content-Length: 6\r\n" +
+		"\r\n" +
+		"Bye.\r\n"
+	expected := "From a@b.c\n" +
+		"Subject: One\n" +
+		"Content-Length: 18\n" +
+		"\n" +
+		"Hi.\nFrom the top.\n" +
+		"\n" +
+		"From d@e.f\n" +
+		"Subject: Two\n" +
+		"Content-Length: 5\n" +
+		"\n" +
+		"Bye.\n" +
+		"\n"
+
+	var out bytes.Buffer
+	var found []string
+	err := Repair(strings.NewReader(mailbox), &out, func(p Problem) {
+		found = append(found, p.Kind.String())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(found, ",") != "crlf-line-ending,crlf-line-ending" {
+		t.Error("Unexpected problems reported: ", found)
+	}
+	if out.String() != expected {
+		t.Errorf("Expected:\n%q\nGot:\n%q", expected, out.String())
+	}
+	if found := collectProblems(t, out.String()); len(found) != 0 {
+		t.Error("Repaired mailbox still has problems: ", found)
+	}
+}
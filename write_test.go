@@ -0,0 +1,395 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rfc5322Message = "From: Foo S. Ball <foo@bar.com>\r\nSubject: Quoting\r\nContent-Length: 9999\r\n\r\nFrom the top:\r\n>From the middle\r\nplain\r\n"
+
+var testEnvelope = Envelope{
+	Sender: "foo@bar.com",
+	Date:   time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC),
+}
+
+// Given a message with From lines in its body
+// When I write it in each dialect
+// Then I expect the body to be escaped as that dialect requires.
+func TestWriter10(t *testing.T) {
+	expected := map[Dialect]string{
+		Mboxo:   ">From the top:\n>From the middle\nplain\n",
+		Mboxrd:  ">From the top:\n>>From the middle\nplain\n",
+		Mboxcl:  ">From the top:\n>From the middle\nplain\n",
+		Mboxcl2: "From the top:\n>From the middle\nplain\n",
+	}
+	for d, body := range expected {
+		var b bytes.Buffer
+		if err := NewWriter(&b, d).WriteMessage(testEnvelope, strings.NewReader(rfc5322Message)); err != nil {
+			t.Error(d, ": ", err)
+			continue
+		}
+		out := b.String()
+		if !strings.HasPrefix(out, "From foo@bar.com Mon Jan  2 15:04:05 2006\n") {
+			t.Errorf("%v: bad envelope in %q", d, out)
+		}
+		if !strings.HasSuffix(out, "\n\n"+body+"\n") {
+			t.Errorf("%v: expected body %q in %q", d, body, out)
+		}
+		hasLength := strings.Contains(out, "Content-Length: ")
+		if hasLength != d.usesContentLength() {
+			t.Errorf("%v: Content-Length presence wrong in %q", d, out)
+		}
+		if d.usesContentLength() && !strings.Contains(out, "Content-Length: "+strconv.Itoa(len(body))+"\n") {
+			t.Errorf("%v: Content-Length not recomputed in %q", d, out)
+		}
+	}
+}
+
+// Given mailboxes written in each dialect
+// When I detect their dialects
+// Then I expect to recover the dialect that wrote them.
+func TestDetectDialect10(t *testing.T) {
+	for _, d := range []Dialect{Mboxo, Mboxrd, Mboxcl, Mboxcl2} {
+		var b bytes.Buffer
+		NewWriter(&b, d).WriteMessage(testEnvelope, strings.NewReader(rfc5322Message))
+		got, err := DetectDialect(&b)
+		if err != nil {
+			t.Error(d, ": ", err)
+		}
+		if got != d {
+			t.Errorf("Expected %v; detected %v", d, got)
+		}
+	}
+}
+
+// Given mailboxes in which not every message is delimited by a correct
+// Content-Length header
+// When I detect their dialects
+// Then I expect an escaping dialect rather than mboxcl2.
+func TestDetectDialect20(t *testing.T) {
+	mailboxes := map[string]Dialect{
+		"From a@b.c\nSubject: x\nContent-Length: 5\n\nhello\n\n":                                 Mboxo,
+		"From a@b.c\nContent-Length: 6\n\nhello\n\nFrom d@e.f\nSubject: y\n\nbye\n":              Mboxo,
+		"From a@b.c\nContent-Length: 6\n\nhello\n\nFrom d@e.f\n\n>>From here\n":                  Mboxrd,
+		"From a@b.c\nContent-Length: 6\n\nhello\n\nFrom d@e.f\nContent-Length: 0\n\n":            Mboxcl2,
+		"From a@b.c\nContent-Length: 17\n\nhello\nFrom there\n\nFrom d@e.f\n\nbye\n":             Mboxo,
+		"From a@b.c\nContent-Length: 17\n\nhello\nFrom there\nFrom d@e.f\nContent-Length: 0\n\n": Mboxcl2,
+	}
+	for mailbox, expected := range mailboxes {
+		d, err := DetectDialect(strings.NewReader(mailbox))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != expected {
+			t.Errorf("Expected %v; detected %v in %q", expected, d, mailbox)
+		}
+	}
+}
+
+// Given a mailbox whose evidence of its dialect lies beyond the first few
+// megabytes
+// When I detect its dialect with DetectDialectAt
+// Then I expect the conservative answer, with the file offset undisturbed.
+func TestDetectDialectAt10(t *testing.T) {
+	var b bytes.Buffer
+	for b.Len() < detectLimit {
+		NewWriter(&b, Mboxo).WriteMessage(testEnvelope, strings.NewReader(rfc5322Message))
+	}
+	NewWriter(&b, Mboxrd).WriteMessage(testEnvelope, strings.NewReader(rfc5322Message))
+	r := bytes.NewReader(b.Bytes())
+	if d, err := DetectDialect(r); err != nil || d != Mboxrd {
+		t.Fatal("Expected the whole mailbox to look like mboxrd: ", d, err)
+	}
+	r.Seek(10, io.SeekStart)
+	d, err := DetectDialectAt(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != Mboxo {
+		t.Errorf("Expected %v; detected %v", Mboxo, d)
+	}
+	if pos, _ := r.Seek(0, io.SeekCurrent); pos != 10 {
+		t.Error("Expected the offset left at 10; got ", pos)
+	}
+}
+
+// Given an existing mailbox lacking its final blank line
+// When I append a message
+// Then I expect a well-separated, readable mailbox with the lock released.
+func TestAppend10(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mboxWith1Message), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(path, testEnvelope, strings.NewReader(rfc5322Message)); err != nil {
+		t.Fatal("TestAppend10: ", err)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("Dotlock file should be removed after appending")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), mboxWith1Message+"\nFrom foo@bar.com Mon Jan  2") {
+		t.Errorf("Previous message not separated properly: %q", data)
+	}
+	var subjects []string
+	s := NewScanner(data)
+	for s.Next() {
+		subjects = append(subjects, string(s.View().Get("Subject")))
+	}
+	if strings.Join(subjects, ",") != "Hello world,Quoting" {
+		t.Error("Unexpected messages after append: ", subjects)
+	}
+}
+
+// Given a mailbox which another process holds locked
+// When I append to it, and meanwhile that process renames a rewritten mailbox into place
+// Then I expect my message to land in the new file, not the orphaned one.
+func TestAppend20(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	if err := os.WriteFile(path, []byte(mboxWith1Message), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lock, err := LockMailbox(f, time.Second)
+	if err != nil {
+		t.Fatal("TestAppend20: ", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Append(path, testEnvelope, strings.NewReader(rfc5322Message))
+	}()
+	time.Sleep(3 * lockRetryInterval)
+
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, []byte(mboxWith1Message), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	lock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatal("Append failed: ", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Subject: Quoting") {
+		t.Errorf("Appended message lost: %q", data)
+	}
+}
+
+// Given no mailbox at all
+// When I append the same message twice
+// Then I expect both copies escaped alike, and read back intact.
+func TestAppend30(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	const message = "Subject: Escapes\n\nFrom the top:\nplain\n"
+	for i := 0; i < 2; i++ {
+		if err := Append(path, testEnvelope, strings.NewReader(message)); err != nil {
+			t.Fatal("TestAppend30: ", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DetectDialect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n>From the top:\n"); n != 2 {
+		t.Errorf("Expected both copies escaped; %d were:\n%s", n, data)
+	}
+	want := "From the top:\nplain\n"
+	s := NewScanner(data)
+	n := 0
+	for ; s.Next(); n++ {
+		var b bytes.Buffer
+		if err := s.View().WriteEML(&b, d); err != nil {
+			t.Fatal(err)
+		}
+		if _, body, _ := strings.Cut(b.String(), "\n\n"); body != want {
+			t.Errorf("Message %d body wrong in %v: %q", n, d, body)
+		}
+	}
+	if n != 2 {
+		t.Error("Expected 2 messages; got ", n)
+	}
+}
+
+// Given a mailbox holding one message with an incorrect Content-Length
+// When I append a message with a From line in its body
+// Then I expect that line escaped, and the mailbox read back intact.
+func TestAppend40(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	const mailbox = "From a@b Mon Jan  2 15:04:05 2006\nSubject: x\nContent-Length: 5\n\nhello\n\n"
+	if err := os.WriteFile(path, []byte(mailbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(path, testEnvelope, strings.NewReader("Subject: y\n\nFrom here on\n")); err != nil {
+		t.Fatal("TestAppend40: ", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n>From here on\n") {
+		t.Errorf("Expected the From line escaped:\n%s", data)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s, err := CreateMboxStream(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for ; ; n++ {
+		msg, err := s.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Message ", n+1, ": ", err)
+		}
+		if _, err := io.Copy(io.Discard, msg.BodyReader()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 2 {
+		t.Error("Expected 2 messages; got ", n)
+	}
+}
+
+// Given a mailbox locked by someone else
+// When I try to lock it myself
+// Then I expect to time out.
+func TestLockMailbox10(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	f1, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	l1, err := LockMailbox(f1, time.Second)
+	if err != nil {
+		t.Fatal("TestLockMailbox10: ", err)
+	}
+	if _, err := LockMailbox(f2, 200*time.Millisecond); err != ErrLockTimeout {
+		t.Error("Expected ErrLockTimeout; got ", err)
+	}
+	l1.Unlock()
+	l2, err := LockMailbox(f2, time.Second)
+	if err != nil {
+		t.Fatal("Lock should be available once released: ", err)
+	}
+	l2.Unlock()
+}
+
+// Given a mailbox I hold locked for a long time
+// When I refresh the lock, and later another process takes over the dotlock
+// Then I expect the dotlock kept fresh, and theirs left alone when I unlock.
+func TestLockMailbox20(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l, err := LockMailbox(f, time.Second)
+	if err != nil {
+		t.Fatal("TestLockMailbox20: ", err)
+	}
+
+	dotlock := path + ".lock"
+	before, err := os.Stat(dotlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := l.Refresh(); err != nil {
+		t.Fatal("Refresh failed: ", err)
+	}
+	if after, err := os.Stat(dotlock); err != nil || !after.ModTime().After(before.ModTime()) {
+		t.Error("Dotlock not refreshed: ", err)
+	}
+
+	os.Remove(dotlock)
+	if err := os.WriteFile(dotlock, []byte("99999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Refresh(); err != ErrLockLost {
+		t.Error("Expected ErrLockLost; got ", err)
+	}
+	l.Unlock()
+	if data, err := os.ReadFile(dotlock); err != nil || string(data) != "99999\n" {
+		t.Error("Another process's dotlock should survive Unlock: ", err)
+	}
+}
+
+// Given an RFC 5322 message with Return-Path and Received headers
+// When I write it without an envelope
+// Then I expect an envelope synthesized from those headers.
+func TestWriter20(t *testing.T) {
+	source := "Return-Path: <bounce@bar.com>\n" +
+		"Received: from mx.bar.com by mail.bar.com; Tue, 3 Jan 2006 10:00:00 +0000\n" +
+		"From: Foo S. Ball <foo@bar.com>\n" +
+		"Date: Mon, 2 Jan 2006 15:04:05 +0000\n" +
+		"Subject: Hi\n\nFrom me to you.\n"
+	var b bytes.Buffer
+	if err := NewWriter(&b, Mboxrd).WriteRFC5322(strings.NewReader(source)); err != nil {
+		t.Fatal("TestWriter20: ", err)
+	}
+	expected := "From bounce@bar.com Tue Jan  3 10:00:00 2006\n" + strings.Replace(source, "\nFrom me", "\n>From me", 1) + "\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q; got %q", expected, b.String())
+	}
+}
+
+// Given a message parsed by net/mail, lacking Return-Path and Received headers
+// When I write it
+// Then I expect the envelope to come from its From and Date headers.
+func TestWriter30(t *testing.T) {
+	source := "From: Foo S. Ball <foo@bar.com>\nDate: Mon, 2 Jan 2006 15:04:05 +0000\nSubject: Hi\n\nBody\n"
+	msg, err := mail.ReadMessage(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := NewWriter(&b, Mboxo).WriteMail(msg); err != nil {
+		t.Fatal("TestWriter30: ", err)
+	}
+	if !strings.HasPrefix(b.String(), testEnvelope.String()+"\nDate: ") {
+		t.Errorf("Unexpected output %q", b.String())
+	}
+}
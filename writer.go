@@ -0,0 +1,187 @@
+// vim: ts=8 noexpandtab ai
+
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// A Writer produces an mbox file in a chosen dialect.
+type Writer struct {
+	w io.Writer
+	d Dialect
+}
+
+// NewWriter decorates an io.Writer with an mbox writer for the given dialect.
+func NewWriter(w io.Writer, d Dialect) *Writer {
+	return &Writer{w: w, d: d}
+}
+
+// Dialect answers the dialect this writer produces.
+func (w *Writer) Dialect() Dialect {
+	return w.d
+}
+
+// WriteMessage appends a single message to the output.  The content must be
+// an RFC 5322 message: a header block, a blank line, then the body.  The
+// envelope supplies the From marker line which precedes it.
+//
+// CRLF line terminators are converted to LF and body lines are escaped as the
+// dialect requires.  Any existing Content-Length header is removed, since
+// escaping may change the body's length; for Mboxcl and Mboxcl2, a new one
+// describing the body as written is added at the end of the header.  A blank
+// line always follows the message, separating it from the next.
+func (w *Writer) WriteMessage(env Envelope, content io.Reader) error {
+	raw, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+
+	header, body := splitMessage(raw)
+
+	var out bytes.Buffer
+	out.WriteString(env.String())
+	out.WriteByte('\n')
+
+	var escaped bytes.Buffer
+	forEachLine(body, func(line []byte) {
+		escaped.Write(w.d.escapeLine(line))
+	})
+	if n := escaped.Len(); n > 0 && escaped.Bytes()[n-1] != '\n' {
+		escaped.WriteByte('\n')
+	}
+
+	forEachLine(header, func(line []byte) {
+		out.Write(w.d.escapeLine(line))
+	})
+	if n := len(header); n > 0 && header[n-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	if w.d.usesContentLength() {
+		out.WriteString("Content-Length: " + strconv.Itoa(escaped.Len()) + "\n")
+	}
+	out.WriteByte('\n')
+	out.Write(escaped.Bytes())
+	out.WriteByte('\n')
+
+	_, err = w.w.Write(out.Bytes())
+	return err
+}
+
+// WriteRFC5322 appends a standalone RFC 5322 message, such as the contents of
+// an .eml file, to the output.  Its envelope is synthesized by EnvelopeOf.
+func (w *Writer) WriteRFC5322(r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	return w.WriteMessage(EnvelopeOf(msg.Header), bytes.NewReader(raw))
+}
+
+// WriteMail appends a message parsed by net/mail to the output.  Its envelope
+// is synthesized by EnvelopeOf.  Since mail.Header doesn't remember the order
+// of its fields, they are written sorted by name; fields sharing a name keep
+// their relative order.
+func (w *Writer) WriteMail(msg *mail.Message) error {
+	var b bytes.Buffer
+	keys := make([]string, 0, len(msg.Header))
+	for k := range msg.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range msg.Header[k] {
+			b.WriteString(k + ": " + v + "\n")
+		}
+	}
+	b.WriteByte('\n')
+	if msg.Body != nil {
+		if _, err := io.Copy(&b, msg.Body); err != nil {
+			return err
+		}
+	}
+	return w.WriteMessage(EnvelopeOf(msg.Header), &b)
+}
+
+// WriteDir appends every .eml file found in the given directory, in order of
+// file name, and answers how many were written.
+func (w *Writer) WriteDir(dir string) (n int, err error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return n, err
+		}
+		err = w.WriteRFC5322(f)
+		f.Close()
+		if err != nil {
+			return n, fmt.Errorf("%s: %v", name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// splitMessage divides an RFC 5322 message into its header block, excluding
+// the blank line which ends it, and its body.  Any Content-Length header is
+// dropped from the header block, since only the writer knows the final body
+// length; WriteMessage adds it back for the dialects which use it.
+func splitMessage(raw []byte) (header, body []byte) {
+	header = raw
+	for p := 0; p < len(raw); {
+		e := lineEnd(raw, p)
+		if isBlankLine(raw[p:e]) {
+			header, body = raw[:p], raw[e:]
+			break
+		}
+		p = e
+	}
+	return removeField(header, "Content-Length"), body
+}
+
+// removeField answers a copy of the header block without any field of the
+// given name, including its continuation lines.
+func removeField(header []byte, name string) []byte {
+	var out []byte
+	skipping := false
+	forEachLine(header, func(line []byte) {
+		if len(line) > 0 && isspace(line[0]) {
+			if !skipping {
+				out = append(out, line...)
+			}
+			return
+		}
+		k := bytes.IndexByte(line, ':')
+		skipping = k > 0 && equalFold(line[:k], name)
+		if !skipping {
+			out = append(out, line...)
+		}
+	})
+	return out
+}
+
+// forEachLine calls fn for each line of buf, including its line terminator.
+// A final unterminated line is passed without one.
+func forEachLine(buf []byte, fn func(line []byte)) {
+	for p := 0; p < len(buf); {
+		e := lineEnd(buf, p)
+		fn(buf[p:e])
+		p = e
+	}
+}